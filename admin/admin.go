@@ -1,13 +1,23 @@
 package admin
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"tgbot/audit"
+	"tgbot/certificate"
+	"tgbot/config"
+	"tgbot/fsm"
+	"tgbot/messenger"
+	"tgbot/metrics"
 	"tgbot/models"
+	"tgbot/results"
 	"tgbot/storage"
 	"time"
 
@@ -15,129 +25,245 @@ import (
 	"github.com/tealeg/xlsx"
 )
 
-func HandleAdminCommand(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
+// Labels of the admin keyboard buttons.
+const (
+	ButtonStatistics          = "Statistika"
+	ButtonBroadcast           = "Habar yuborish"
+	ButtonAddChannel          = "Kanal qo'shish"
+	ButtonRemoveChannel       = "Kanal o'chirish"
+	ButtonCreateTest          = "Test yaratish"
+	ButtonTests               = "Testlar"
+	ButtonResetAttempts       = "Urinishlarni tiklash"
+	ButtonLeaderboard         = "Reyting"
+	ButtonCertificateTemplate = "Sertifikat shabloni"
+	ButtonAddAdmin            = "Admin qo'shish"
+	ButtonRemoveAdmin         = "Admin o'chirish"
+	ButtonDBDump              = "DB olish"
+	ButtonUsersDump           = "Users olish"
+	ButtonAuditLog            = "Audit jurnali"
+)
 
-	if !storage.IsAdmin(int(chatID), db) {
-		msgResponse := tgbotapi.NewMessage(chatID, "Siz admin emassiz.")
-		botInstance.Send(msgResponse)
-		return
+// keyboardRows lays out the admin keyboard. Every button needs the
+// permission next to it; rows left without buttons are dropped.
+var keyboardRows = [][]struct{ label, permission string }{
+	{{ButtonStatistics, models.PermissionViewStats}, {ButtonBroadcast, models.PermissionBroadcast}},
+	{{ButtonAddChannel, models.PermissionManageChannels}, {ButtonRemoveChannel, models.PermissionManageChannels}},
+	{{ButtonCreateTest, models.PermissionUploadTests}, {ButtonTests, models.PermissionUploadTests}},
+	{{ButtonResetAttempts, models.PermissionUploadTests}, {ButtonLeaderboard, models.PermissionViewStats}},
+	{{ButtonCertificateTemplate, models.PermissionManageSettings}},
+	{{ButtonAddAdmin, models.PermissionManageAdmins}, {ButtonRemoveAdmin, models.PermissionManageAdmins}},
+	{{ButtonDBDump, models.PermissionExportData}, {ButtonUsersDump, models.PermissionExportData}},
+	{{ButtonAuditLog, models.PermissionViewAudit}},
+}
+
+// Commands maps the messages only admins may send to the permission they
+// need. An empty permission means any admin role.
+var Commands = commands()
+
+func commands() map[string]string {
+	commands := map[string]string{"/admin": ""}
+	for _, row := range keyboardRows {
+		for _, button := range row {
+			commands[button.label] = button.permission
+		}
 	}
+	return commands
+}
 
-	adminKeyboard := tgbotapi.NewReplyKeyboard(
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("Statistika"),
-			tgbotapi.NewKeyboardButton("Habar yuborish"),
-		),
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("Kanal qo'shish"),
-			tgbotapi.NewKeyboardButton("Kanal o'chirish"),
-		),
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("Test faylini yuklash"),
-			tgbotapi.NewKeyboardButton("Test javoblarini yuklash"),
-		),
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("Admin qo'shish"),
-			tgbotapi.NewKeyboardButton("Admin o'chirish"),
-		),
-		tgbotapi.NewKeyboardButtonRow(
-            tgbotapi.NewKeyboardButton("DB olish"),  // New button added here
-			tgbotapi.NewKeyboardButton("Users olish"),
-        ),
-	)
+// CallbackPrefixes maps the data prefixes of admin inline buttons to the
+// permission needed to press them.
+var CallbackPrefixes = map[string]string{
+	"toggle_test_":            models.PermissionUploadTests,
+	"publish_results_":        models.PermissionUploadTests,
+	"delete_channel_":         models.PermissionManageChannels,
+	"confirm_delete_channel_": models.PermissionManageChannels,
+	"cancel_delete_channel":   models.PermissionManageChannels,
+	"audit_page_":             models.PermissionViewAudit,
+	"audit_export":            models.PermissionViewAudit,
+}
+
+func HandleAdminCommand(ctx context.Context, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) {
+	chatID := msg.Chat.ID
 
 	msgResponse := tgbotapi.NewMessage(chatID, "Admin buyrug'lari:")
-	msgResponse.ReplyMarkup = adminKeyboard
+	msgResponse.ReplyMarkup = Keyboard(userRole(ctx, chatID, repos.Admins))
 	botInstance.Send(msgResponse)
 }
 
-func HandleChannelLink(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	channelLink := msg.Text
+// Keyboard is the reply keyboard with the admin commands the role may use.
+func Keyboard(role string) tgbotapi.ReplyKeyboardMarkup {
+	var rows [][]tgbotapi.KeyboardButton
+	for _, row := range keyboardRows {
+		var buttons []tgbotapi.KeyboardButton
+		for _, button := range row {
+			if models.RoleCan(role, button.permission) {
+				buttons = append(buttons, tgbotapi.NewKeyboardButton(button.label))
+			}
+		}
+		if len(buttons) > 0 {
+			rows = append(rows, tgbotapi.NewKeyboardButtonRow(buttons...))
+		}
+	}
+	return tgbotapi.NewReplyKeyboard(rows...)
+}
 
-	err := storage.AddChannelToDatabase(db, channelLink)
+// userRole returns the admin role of the user, or "" if they have none.
+func userRole(ctx context.Context, userID int64, admins storage.AdminRepo) string {
+	role, err := admins.Role(ctx, userID)
 	if err != nil {
-		log.Printf("Error adding channel to database: %v", err)
-		msgResponse := tgbotapi.NewMessage(chatID, "Kanalni qo'shishda xatolik yuz berdi.")
-		botInstance.Send(msgResponse)
-		return
+		if err != storage.ErrNotFound {
+			slog.ErrorContext(ctx, "Error getting admin role", "user_id", userID, "err", err)
+		}
+		return ""
 	}
+	return role
+}
 
-	msgResponse := tgbotapi.NewMessage(chatID, "Kanal muvaffaqiyatli qo'shildi.")
-	botInstance.Send(msgResponse)
+// canManageTest reports whether a user with the role may open, close, publish
+// or reset attempts of the test: roles managing tests may change any test,
+// the others only the tests the user created.
+func canManageTest(role string, userID int64, test models.Test) bool {
+	return models.RoleCan(role, models.PermissionManageTests) ||
+		(models.RoleCan(role, models.PermissionUploadTests) && test.CreatedBy == userID)
 }
 
-func DeleteChannel(chatID int64, messageID int, channel string, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	err := storage.DeleteChannelFromDatabase(db, channel)
+// notYourTest is shown when canManageTest denies a test.
+const notYourTest = "Siz faqat o'zingiz yaratgan testlarni boshqara olasiz."
+
+// denyTest tells the user they may not change the test and records it.
+func denyTest(ctx context.Context, chatID int64, testID int, repos *storage.Repos, botInstance messenger.Messenger) {
+	slog.WarnContext(ctx, "Denied action on test of another admin", "test_id", testID, "user_id", chatID)
+	audit.Record(ctx, repos.Audit, chatID, audit.ActionDenied, audit.Test(testID), "", notYourTest)
+	botInstance.Send(tgbotapi.NewMessage(chatID, notYourTest))
+}
+
+// ReturnToMenu returns a flow's Cancel that confirms with text and brings back
+// the admin keyboard.
+func ReturnToMenu(text string) func(c *fsm.Context) {
+	return func(c *fsm.Context) {
+		msgResponse := tgbotapi.NewMessage(c.ChatID, text)
+		msgResponse.ReplyMarkup = Keyboard(userRole(c.Ctx, c.ChatID, c.Repos.Admins))
+		c.Bot.Send(msgResponse)
+	}
+}
+
+func HandleChannelLink(c *fsm.Context) (string, error) {
+	channelLink := strings.TrimSpace(c.Text())
+
+	err := c.Repos.Channels.Add(c.Ctx, channelLink)
 	if err != nil {
-		log.Printf("Error deleting channel from database: %v", err)
+		return fsm.End, fsm.Fail("Kanalni qo'shishda xatolik yuz berdi.", fmt.Errorf("error adding channel to database: %v", err))
+	}
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionAddChannel, channelLink, "", channelLink)
+
+	c.Reply("Kanal muvaffaqiyatli qo'shildi.")
+	return fsm.End, nil
+}
+
+func DeleteChannel(ctx context.Context, chatID int64, messageID int, channel string, repos *storage.Repos, botInstance messenger.Messenger) {
+	err := repos.Channels.Delete(ctx, channel)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error deleting channel from database", "err", err)
 		msgResponse := tgbotapi.NewMessage(chatID, "Kanalni o'chirishda xatolik yuz berdi.")
 		botInstance.Send(msgResponse)
 		return
 	}
+	audit.Record(ctx, repos.Audit, chatID, audit.ActionDeleteChannel, channel, channel, "")
 
 	msgResponse := tgbotapi.NewMessage(chatID, fmt.Sprintf("%s kanali muvaffaqiyatli o'chirildi.", channel))
 	botInstance.Send(msgResponse)
 }
 
-func CancelChannelDeletion(chatID int64, messageID int, botInstance *tgbotapi.BotAPI) {
+func CancelChannelDeletion(chatID int64, messageID int, botInstance messenger.Messenger) {
 	msgResponse := tgbotapi.NewMessage(chatID, "Kanal o'chirish bekor qilindi.")
 	botInstance.Send(msgResponse)
 
 	// Delete the previous message
-	deleteMsg := tgbotapi.NewDeleteMessage(chatID, messageID)
-	botInstance.Send(deleteMsg)
+	botInstance.Delete(chatID, messageID)
 }
 
-func HandleAdminAdd(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	adminID, err := strconv.ParseInt(msg.Text, 10, 64)
+func HandleAdminAdd(c *fsm.Context) (string, error) {
+	adminID, role, _ := parseAdminGrant(c.Text())
+	oldRole := userRole(c.Ctx, adminID, c.Repos.Admins)
+
+	err := c.Repos.Admins.Add(c.Ctx, adminID, role)
 	if err != nil {
-		log.Printf("Error parsing admin ID: %v", err)
-		msgResponse := tgbotapi.NewMessage(chatID, "Noto'g'ri admin ID formati.")
-		botInstance.Send(msgResponse)
-		return
+		return fsm.End, fsm.Fail("Admin qo'shishda xatolik yuz berdi.", fmt.Errorf("error adding admin to database: %v", err))
 	}
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionAddAdmin, audit.User(adminID), oldRole, role)
+
+	c.Reply(fmt.Sprintf("Admin muvaffaqiyatli qo'shildi. Roli: %s", role))
+	return fsm.End, nil
+}
 
-	err = storage.AddAdminToDatabase(db, adminID)
+func validateAdminGrant(c *fsm.Context) error {
+	_, _, err := parseAdminGrant(c.Text())
+	return err
+}
+
+// parseAdminGrant parses "admin_id [role]". The role defaults to admin.
+func parseAdminGrant(text string) (adminID int64, role string, err error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, "", errors.New("Noto'g'ri format. Namuna: 123456789 teacher")
+	}
+
+	adminID, err = parseAdminID(fields[0])
 	if err != nil {
-		log.Printf("Error adding admin to database: %v", err)
-		msgResponse := tgbotapi.NewMessage(chatID, "Admin qo'shishda xatolik yuz berdi.")
-		botInstance.Send(msgResponse)
-		return
+		return 0, "", errors.New("Noto'g'ri admin ID formati. Iltimos, qaytadan yuboring:")
 	}
 
-	msgResponse := tgbotapi.NewMessage(chatID, "Admin muvaffaqiyatli qo'shildi.")
-	botInstance.Send(msgResponse)
+	role = models.RoleAdmin
+	if len(fields) == 2 {
+		role = strings.ToLower(fields[1])
+		if !models.ValidRole(role) {
+			return 0, "", fmt.Errorf("Noma'lum rol %q. Rollar: %s", fields[1], strings.Join(models.Roles, ", "))
+		}
+	}
+	return adminID, role, nil
 }
 
-func HandleAdminRemove(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	adminID, err := strconv.ParseInt(msg.Text, 10, 64)
+func HandleAdminRemove(c *fsm.Context) (string, error) {
+	adminID, _ := parseAdminID(c.Text())
+	oldRole := userRole(c.Ctx, adminID, c.Repos.Admins)
+
+	err := c.Repos.Admins.Remove(c.Ctx, adminID)
 	if err != nil {
-		log.Printf("Error parsing admin ID: %v", err)
-		msgResponse := tgbotapi.NewMessage(chatID, "Noto'g'ri admin ID formati.")
-		botInstance.Send(msgResponse)
-		return
+		return fsm.End, fsm.Fail("Admin o'chirishda xatolik yuz berdi.", fmt.Errorf("error removing admin from database: %v", err))
 	}
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionRemoveAdmin, audit.User(adminID), oldRole, "")
+
+	c.Reply("Admin muvaffaqiyatli o'chirildi.")
+	return fsm.End, nil
+}
 
-	err = storage.RemoveAdminFromDatabase(db, adminID)
+func validateAdminRemoval(c *fsm.Context) error {
+	adminID, err := parseAdminID(c.Text())
 	if err != nil {
-		log.Printf("Error removing admin from database: %v", err)
-		msgResponse := tgbotapi.NewMessage(chatID, "Admin o'chirishda xatolik yuz berdi.")
-		botInstance.Send(msgResponse)
-		return
+		return errors.New("Noto'g'ri admin ID formati. Iltimos, qaytadan yuboring:")
+	}
+	// Otherwise the last owner could lock everyone out of admin management
+	if adminID == c.ChatID {
+		return errors.New("O'zingizni o'chira olmaysiz. Boshqa admin ID sini yuboring:")
 	}
+	return nil
+}
 
-	msgResponse := tgbotapi.NewMessage(chatID, "Admin muvaffaqiyatli o'chirildi.")
-	botInstance.Send(msgResponse)
+func requireText(c *fsm.Context) error {
+	if strings.TrimSpace(c.Text()) == "" {
+		return errors.New("Iltimos, matn yuboring:")
+	}
+	return nil
+}
+
+func parseAdminID(text string) (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(text), 10, 64)
 }
 
-func DisplayChannelsForDeletion(chatID int64, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	channels, err := storage.GetChannelsFromDatabase(db)
+func DisplayChannelsForDeletion(ctx context.Context, chatID int64, repos *storage.Repos, botInstance messenger.Messenger) {
+	channels, err := repos.Channels.All(ctx)
 	if err != nil {
-		log.Printf("Error getting channels from database: %v", err)
+		slog.ErrorContext(ctx, "Error getting channels from database", "err", err)
 		msgResponse := tgbotapi.NewMessage(chatID, "Kanallarni olishda xatolik yuz berdi.")
 		botInstance.Send(msgResponse)
 		return
@@ -155,7 +281,7 @@ func DisplayChannelsForDeletion(chatID int64, db *sql.DB, botInstance *tgbotapi.
 	botInstance.Send(msgResponse)
 }
 
-func AskForChannelDeletionConfirmation(chatID int64, messageID int, channel string, botInstance *tgbotapi.BotAPI) {
+func AskForChannelDeletionConfirmation(chatID int64, messageID int, channel string, botInstance messenger.Messenger) {
 	confirmButton := tgbotapi.NewInlineKeyboardButtonData("Ha", "confirm_delete_channel_"+channel)
 	cancelButton := tgbotapi.NewInlineKeyboardButtonData("Yo'q", "cancel_delete_channel")
 
@@ -167,33 +293,241 @@ func AskForChannelDeletionConfirmation(chatID int64, messageID int, channel stri
 	botInstance.Send(msgResponse)
 
 	// Delete the previous message
-	deleteMsg := tgbotapi.NewDeleteMessage(chatID, messageID)
-	botInstance.Send(deleteMsg)
+	botInstance.Delete(chatID, messageID)
+}
+
+func DisplayTests(ctx context.Context, chatID int64, repos *storage.Repos, botInstance messenger.Messenger) {
+	tests, err := repos.Tests.All(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting tests from database", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Testlarni olishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	role := userRole(ctx, chatID, repos.Admins)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, test := range tests {
+		if !canManageTest(role, chatID, test) {
+			continue
+		}
+		label := fmt.Sprintf("#%d %s (%s)", test.ID, test.Title, test.Status)
+		button := tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("toggle_test_%d", test.ID))
+		row := tgbotapi.NewInlineKeyboardRow(button)
+		if test.Status != models.TestStatusDraft && !test.ResultsVisible() {
+			publishButton := tgbotapi.NewInlineKeyboardButtonData("Natijalarni e'lon qilish", fmt.Sprintf("publish_results_%d", test.ID))
+			row = append(row, publishButton)
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		msgResponse := tgbotapi.NewMessage(chatID, "Hozircha testlar yo'q.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	inlineKeyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	msgResponse := tgbotapi.NewMessage(chatID, "Testni ochish yoki yopish uchun tanlang:")
+	msgResponse.ReplyMarkup = inlineKeyboard
+	botInstance.Send(msgResponse)
 }
 
-func HandleStatistics(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
+func ToggleTestStatus(ctx context.Context, chatID int64, messageID int, testID int, repos *storage.Repos, botInstance messenger.Messenger) {
+	test, err := repos.Tests.Get(ctx, testID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting test from database", "test_id", testID, "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Testni olishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+	if !canManageTest(userRole(ctx, chatID, repos.Admins), chatID, test) {
+		denyTest(ctx, chatID, testID, repos, botInstance)
+		return
+	}
+
+	if test.Status == models.TestStatusDraft {
+		msgResponse := tgbotapi.NewMessage(chatID, "Test fayli yoki javoblari hali yuklanmagan.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	status := models.TestStatusActive
+	if test.Status == models.TestStatusActive {
+		status = models.TestStatusClosed
+	}
+
+	if err := repos.Tests.UpdateStatus(ctx, testID, status); err != nil {
+		slog.ErrorContext(ctx, "Error updating test status", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Test holatini o'zgartirishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+	audit.Record(ctx, repos.Audit, chatID, audit.ActionSetTestStatus, audit.Test(testID), test.Status, status)
+
+	// Delete the previous message
+	botInstance.Delete(chatID, messageID)
+
+	msgResponse := tgbotapi.NewMessage(chatID, fmt.Sprintf("%q testi holati: %s", test.Title, status))
+	botInstance.Send(msgResponse)
+
+	if status == models.TestStatusClosed && test.ResultsVisibility == models.ResultsAfterDeadline && !test.ResultsPublished {
+		PublishTestResults(ctx, chatID, 0, testID, repos, botInstance)
+	}
+}
+
+func PublishTestResults(ctx context.Context, chatID int64, messageID int, testID int, repos *storage.Repos, botInstance messenger.Messenger) {
+	test, err := repos.Tests.Get(ctx, testID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting test from database", "test_id", testID, "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Testni olishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+	if !canManageTest(userRole(ctx, chatID, repos.Admins), chatID, test) {
+		denyTest(ctx, chatID, testID, repos, botInstance)
+		return
+	}
+
+	participants, err := results.Publish(ctx, testID, repos, botInstance)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error publishing results of test", "test_id", testID, "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Natijalarni e'lon qilishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+	audit.Record(ctx, repos.Audit, chatID, audit.ActionPublishResults, audit.Test(testID), "", fmt.Sprintf("%d participants", participants))
+
+	if messageID != 0 {
+		// Delete the previous message
+		botInstance.Delete(chatID, messageID)
+	}
+
+	msgResponse := tgbotapi.NewMessage(chatID, fmt.Sprintf("Natijalar e'lon qilindi va %d ishtirokchiga yuborilmoqda...", participants))
+	botInstance.Send(msgResponse)
+}
+
+func HandleAttemptReset(c *fsm.Context) (string, error) {
+	userID, testID, _ := parseAttemptReset(c.Text())
+
+	test, err := c.Repos.Tests.Get(c.Ctx, testID)
+	if err == storage.ErrNotFound {
+		return fsm.End, fsm.Fail(fmt.Sprintf("%d raqamli test topilmadi.", testID), err)
+	}
+	if err != nil {
+		return fsm.End, fsm.Fail("Testni olishda xatolik yuz berdi.", fmt.Errorf("error getting test: %v", err))
+	}
+	if !canManageTest(userRole(c.Ctx, c.ChatID, c.Repos.Admins), c.ChatID, test) {
+		audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionDenied, audit.Test(testID), "", notYourTest)
+		return fsm.End, fsm.Fail(notYourTest, fmt.Errorf("test %d was created by %d", testID, test.CreatedBy))
+	}
+
+	reset, err := c.Repos.Submissions.ResetAttempts(c.Ctx, userID, testID)
+	if err != nil {
+		return fsm.End, fsm.Fail("Urinishlarni tiklashda xatolik yuz berdi.", fmt.Errorf("error resetting attempts: %v", err))
+	}
+	target := fmt.Sprintf("%s, %s", audit.User(userID), audit.Test(testID))
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionResetAttempts, target, fmt.Sprintf("%d attempts", reset), "")
+
+	if err := c.Repos.Sessions.Delete(c.Ctx, userID, testID); err != nil {
+		slog.ErrorContext(c.Ctx, "Error deleting test session", "err", err)
+	}
+
+	if err := c.Repos.Users.UpdateRate(c.Ctx, userID); err != nil {
+		slog.ErrorContext(c.Ctx, "Error updating user rate", "err", err)
+	}
+
+	c.Reply(fmt.Sprintf("%d ta urinish bekor qilindi. Foydalanuvchi testni qayta topshirishi mumkin.", reset))
+	return fsm.End, nil
+}
+
+func validateAttemptReset(c *fsm.Context) error {
+	_, _, err := parseAttemptReset(c.Text())
+	return err
+}
+
+// parseAttemptReset parses "user_id test_id".
+func parseAttemptReset(text string) (userID int64, testID int, err error) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return 0, 0, errors.New("Noto'g'ri format. Namuna: 123456789 4")
+	}
+
+	userID, err = strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("Noto'g'ri foydalanuvchi ID formati.")
+	}
+
+	testID, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, errors.New("Noto'g'ri test ID formati.")
+	}
+
+	return userID, testID, nil
+}
+
+func AskForCertificateTemplate(c *fsm.Context) {
+	template, err := c.Repos.Settings.Get(c.Ctx, certificate.TemplateSetting)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			slog.ErrorContext(c.Ctx, "Error getting certificate template", "err", err)
+		}
+		template = certificate.DefaultTemplate
+	}
+
+	c.Reply(fmt.Sprintf(
+		"Joriy shablon:\n\n%s\n\nYangi shablonni yuboring. Birinchi qator sarlavha bo'ladi. Mavjud qiymatlar: %s",
+		template, certificate.Placeholders,
+	))
+}
+
+func HandleCertificateTemplate(c *fsm.Context) (string, error) {
+	template := strings.TrimSpace(c.Text())
+	oldTemplate, err := c.Repos.Settings.Get(c.Ctx, certificate.TemplateSetting)
+	if err != nil && err != storage.ErrNotFound {
+		slog.ErrorContext(c.Ctx, "Error getting certificate template", "err", err)
+	}
+
+	err = c.Repos.Settings.Set(c.Ctx, certificate.TemplateSetting, template)
+	if err != nil {
+		return fsm.End, fsm.Fail("Shablonni saqlashda xatolik yuz berdi.", fmt.Errorf("error saving certificate template: %v", err))
+	}
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionSetCertificateTemplate, certificate.TemplateSetting, oldTemplate, template)
+
+	c.Reply("Sertifikat shabloni saqlandi.")
+	return fsm.End, nil
+}
+
+func validateCertificateTemplate(c *fsm.Context) error {
+	if strings.TrimSpace(c.Text()) == "" {
+		return errors.New("Shablon bo'sh bo'lmasligi kerak. Iltimos, qaytadan yuboring:")
+	}
+	return nil
+}
+
+func HandleStatistics(ctx context.Context, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) {
 	chatID := msg.Chat.ID
 
 	// Fetch user statistics from the database
-	totalUsers, err := storage.GetTotalUsers(db)
+	totalUsers, err := repos.Users.Count(ctx)
 	if err != nil {
-		log.Printf("Error getting total users: %v", err)
+		slog.ErrorContext(ctx, "Error getting total users", "err", err)
 		msgResponse := tgbotapi.NewMessage(chatID, "Statistikani olishda xatolik yuz berdi.")
 		botInstance.Send(msgResponse)
 		return
 	}
 
-	todayUsers, err := storage.GetTodayUsers(db)
+	todayUsers, err := repos.Users.CountSince(ctx, time.Now().Truncate(24 * time.Hour))
 	if err != nil {
-		log.Printf("Error getting today's users: %v", err)
+		slog.ErrorContext(ctx, "Error getting today's users", "err", err)
 		msgResponse := tgbotapi.NewMessage(chatID, "Statistikani olishda xatolik yuz berdi.")
 		botInstance.Send(msgResponse)
 		return
 	}
 
-	lastMonthUsers, err := storage.GetLastMonthUsers(db)
+	lastMonthUsers, err := repos.Users.CountSince(ctx, time.Now().AddDate(0, -1, 0))
 	if err != nil {
-		log.Printf("Error getting last month's users: %v", err)
+		slog.ErrorContext(ctx, "Error getting last month's users", "err", err)
 		msgResponse := tgbotapi.NewMessage(chatID, "Statistikani olishda xatolik yuz berdi.")
 		botInstance.Send(msgResponse)
 		return
@@ -209,21 +543,12 @@ func HandleStatistics(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.B
 	botInstance.Send(msgResponse)
 }
 
-func HandleBroadcastMessage(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-    chatID := msg.Chat.ID
+func HandleBroadcastMessage(c *fsm.Context) (string, error) {
+    msg := c.Msg
 
-    if msg.Text == "/cancel" {
-        msgResponse := tgbotapi.NewMessage(chatID, "Habar yuborish bekor qilindi.")
-        botInstance.Send(msgResponse)
-        return
-    }
-
-    users, err := storage.GetAllUsers(db)
+    users, err := c.Repos.Users.All(c.Ctx)
     if err != nil {
-        log.Printf("Error retrieving users: %v", err)
-        msgResponse := tgbotapi.NewMessage(chatID, "Foydalanuvchilarni olishda xatolik yuz berdi.")
-        botInstance.Send(msgResponse)
-        return
+        return fsm.End, fsm.Fail("Foydalanuvchilarni olishda xatolik yuz berdi.", fmt.Errorf("error retrieving users: %v", err))
     }
 
     var photoFileID string
@@ -231,15 +556,19 @@ func HandleBroadcastMessage(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbo
         photoFileID = (*msg.Photo)[len(*msg.Photo)-1].FileID
     }
 
-    go sendBroadcastMessage(users, msg.Caption, photoFileID, chatID, botInstance)
-    msgResponse := tgbotapi.NewMessage(chatID, fmt.Sprintf("Habar %d foydalanuvchilarga yuborilmoqda...", len(users)))
-    botInstance.Send(msgResponse)
+    audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionBroadcast, fmt.Sprintf("%d users", len(users)), "", msg.Caption)
+    go sendBroadcastMessage(context.WithoutCancel(c.Ctx), users, msg.Caption, photoFileID, c.ChatID, c.Bot)
+    c.Reply(fmt.Sprintf("Habar %d foydalanuvchilarga yuborilmoqda...", len(users)))
+    return fsm.End, nil
 }
 
-func sendBroadcastMessage(users []models.User, message, photoFileID string, adminChatID int64, botInstance *tgbotapi.BotAPI) {
-    ticker := time.NewTicker(200 * time.Millisecond) // Allows 5 messages per second
+func sendBroadcastMessage(ctx context.Context, users []models.User, message, photoFileID string, adminChatID int64, botInstance messenger.Messenger) {
+    ticker := time.NewTicker(config.Get().BroadcastInterval())
     defer ticker.Stop()
 
+    metrics.BroadcastRecipients.Set(float64(len(users)))
+    metrics.BroadcastRemaining.Add(float64(len(users)))
+
     count := 0
     for _, user := range users {
         <-ticker.C
@@ -254,44 +583,43 @@ func sendBroadcastMessage(users []models.User, message, photoFileID string, admi
             _, err = botInstance.Send(msg)
         }
 
+        metrics.BroadcastRemaining.Add(-1)
         if err != nil {
-            log.Printf("Error sending message to user %d: %v", user.ID, err)
+            slog.ErrorContext(ctx, "Error sending broadcast message", "user_id", user.ID, "err", err)
+            metrics.BroadcastMessages.Inc("failed")
         } else {
             count++
+            metrics.BroadcastMessages.Inc("sent")
         }
     }
 
-    log.Printf("Broadcast completed. Sent %d messages.", count)
+    slog.InfoContext(ctx, "Broadcast completed", "count", count)
     msgResponse := tgbotapi.NewMessage(adminChatID, fmt.Sprintf("Broadcast completed. Sent %d messages.", count))
     botInstance.Send(msgResponse)
 }
 
-func HandleDBDump(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
+func HandleDBDump(ctx context.Context, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) {
     chatID := msg.Chat.ID
 
-    if !storage.IsAdmin(int(chatID), db) {
-        msgResponse := tgbotapi.NewMessage(chatID, "Siz admin emassiz.")
-        botInstance.Send(msgResponse)
-        return
-    }
-
+    cfg := config.Get()
     timestamp := time.Now().Format("20060102_150405")
     filename := fmt.Sprintf("backup_%s.sql", timestamp)
-    cmd := exec.Command("pg_dump", "-U", "godb", "-d", "testbot", "-f", filename) // Adjust the command to match your DB settings
+    path := filepath.Join(cfg.DumpDir, filename)
+    cmd := exec.CommandContext(ctx, "pg_dump", "-h", cfg.DB.Host, "-p", strconv.Itoa(cfg.DB.Port), "-U", cfg.DB.User, "-d", cfg.DB.Name, "-f", path)
 
-    cmd.Env = append(os.Environ(), "PGPASSWORD=0208")  // Add the password to the environment variables
+    cmd.Env = append(os.Environ(), "PGPASSWORD="+cfg.DB.Password)  // Add the password to the environment variables
 
     output, err := cmd.CombinedOutput()  // Capture combined stdout and stderr output
     if err != nil {
-        log.Printf("Error dumping database: %v\nOutput: %s", err, string(output))
+        slog.ErrorContext(ctx, "Error dumping database", "err", err, "output", string(output))
         msgResponse := tgbotapi.NewMessage(chatID, fmt.Sprintf("Database dumping failed. Output: %s", string(output)))
         botInstance.Send(msgResponse)
         return
     }
 
-    fileBytes, err := os.ReadFile(filename)
+    fileBytes, err := os.ReadFile(path)
     if err != nil {
-        log.Printf("Error reading dump file: %v", err)
+        slog.ErrorContext(ctx, "Error reading dump file", "err", err)
         msgResponse := tgbotapi.NewMessage(chatID, "Error reading dump file.")
         botInstance.Send(msgResponse)
         return
@@ -302,32 +630,28 @@ func HandleDBDump(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAP
         Bytes: fileBytes,
     })
     if _, err := botInstance.Send(document); err != nil {
-        log.Printf("Error sending dump file: %v", err)
+        slog.ErrorContext(ctx, "Error sending dump file", "err", err)
         msgResponse := tgbotapi.NewMessage(chatID, "Error sending dump file.")
         botInstance.Send(msgResponse)
         return
     }
+    audit.Record(ctx, repos.Audit, chatID, audit.ActionExportDB, cfg.DB.Name, "", filename)
 
     // Optionally, delete the file after sending it
-    os.Remove(filename)
+    os.Remove(path)
 }
 
-func HandleUsersDump(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
+func HandleUsersDump(ctx context.Context, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) {
     chatID := msg.Chat.ID
 
-    if !storage.IsAdmin(int(chatID), db) {
-        msgResponse := tgbotapi.NewMessage(chatID, "Siz admin emassiz.")
-        botInstance.Send(msgResponse)
-        return
-    }
-
     timestamp := time.Now().Format("20060102_150405")
     filename := fmt.Sprintf("users_%s.xlsx", timestamp)
+    path := filepath.Join(config.Get().DumpDir, filename)
 
     file := xlsx.NewFile()
     sheet, err := file.AddSheet("Users")
     if err != nil {
-        log.Printf("Error creating sheet: %v", err)
+        slog.ErrorContext(ctx, "Error creating sheet", "err", err)
         msgResponse := tgbotapi.NewMessage(chatID, "Excel fayl yaratishda xatolik yuz berdi.")
         botInstance.Send(msgResponse)
         return
@@ -343,9 +667,9 @@ func HandleUsersDump(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.Bo
     row.AddCell().Value = "Grade"
     row.AddCell().Value = "Phone"
 
-    users, err := storage.GetAllUsersDetailed(db)
+    users, err := repos.Users.AllDetailed(ctx)
     if err != nil {
-        log.Printf("Error retrieving users: %v", err)
+        slog.ErrorContext(ctx, "Error retrieving users", "err", err)
         msgResponse := tgbotapi.NewMessage(chatID, "Foydalanuvchilarni olishda xatolik yuz berdi.")
         botInstance.Send(msgResponse)
         return
@@ -363,17 +687,17 @@ func HandleUsersDump(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.Bo
         row.AddCell().Value = user.Phone
     }
 
-    err = file.Save(filename)
+    err = file.Save(path)
     if err != nil {
-        log.Printf("Error saving Excel file: %v", err)
+        slog.ErrorContext(ctx, "Error saving Excel file", "err", err)
         msgResponse := tgbotapi.NewMessage(chatID, "Excel faylini saqlashda xatolik yuz berdi.")
         botInstance.Send(msgResponse)
         return
     }
 
-    fileBytes, err := os.ReadFile(filename)
+    fileBytes, err := os.ReadFile(path)
     if err != nil {
-        log.Printf("Excel faylini o'qishda xatolik: %v", err)
+        slog.ErrorContext(ctx, "Excel faylini o'qishda xatolik", "err", err)
         msgResponse := tgbotapi.NewMessage(chatID, "Excel faylini o'qishda xatolik.")
         botInstance.Send(msgResponse)
         return
@@ -384,12 +708,13 @@ func HandleUsersDump(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.Bo
         Bytes: fileBytes,
     })
     if _, err := botInstance.Send(document); err != nil {
-        log.Printf("Excel faylini yuborishda xatolik: %v", err)
+        slog.ErrorContext(ctx, "Excel faylini yuborishda xatolik", "err", err)
         msgResponse := tgbotapi.NewMessage(chatID, "Excel faylini yuborishda xatolik.")
         botInstance.Send(msgResponse)
         return
     }
+    audit.Record(ctx, repos.Audit, chatID, audit.ActionExportUsers, fmt.Sprintf("%d users", len(users)), "", filename)
 
     // Ixtiyoriy ravishda faylni yuborganingizdan keyin o'chirishingiz mumkin
-    os.Remove(filename)
+    os.Remove(path)
 }
\ No newline at end of file
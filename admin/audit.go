@@ -0,0 +1,179 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"tgbot/audit"
+	"tgbot/config"
+	"tgbot/messenger"
+	"tgbot/models"
+	"tgbot/storage"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/tealeg/xlsx"
+)
+
+// auditPageSize is the number of audit entries shown per message.
+const auditPageSize = 10
+
+// auditValueLength limits old and new values in messages; exports keep them whole.
+const auditValueLength = 40
+
+func HandleAuditLog(ctx context.Context, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) {
+	ShowAuditPage(ctx, msg.Chat.ID, 0, 0, repos, botInstance)
+}
+
+// ShowAuditPage sends the given page of the audit log, newest entries first,
+// replacing the message with the previous page if messageID is set.
+func ShowAuditPage(ctx context.Context, chatID int64, messageID int, page int, repos *storage.Repos, botInstance messenger.Messenger) {
+	total, err := repos.Audit.Count(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error counting audit entries", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Audit jurnalini olishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	if total == 0 {
+		msgResponse := tgbotapi.NewMessage(chatID, "Audit jurnali bo'sh.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	pages := (total + auditPageSize - 1) / auditPageSize
+	if page < 0 || page >= pages {
+		page = 0
+	}
+
+	entries, err := repos.Audit.Page(ctx, auditPageSize, page*auditPageSize)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting audit entries", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Audit jurnalini olishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	if messageID != 0 {
+		// Delete the previous message
+		botInstance.Delete(chatID, messageID)
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Audit jurnali (%d/%d):\n", page+1, pages)
+	for _, entry := range entries {
+		text.WriteString("\n" + formatAuditEntry(entry))
+	}
+
+	var navigation []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("« Oldingi", fmt.Sprintf("audit_page_%d", page-1)))
+	}
+	if page+1 < pages {
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("Keyingi »", fmt.Sprintf("audit_page_%d", page+1)))
+	}
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Excel faylga yuklash", "audit_export")),
+	}
+	if len(navigation) > 0 {
+		rows = append([][]tgbotapi.InlineKeyboardButton{navigation}, rows...)
+	}
+
+	msgResponse := tgbotapi.NewMessage(chatID, text.String())
+	msgResponse.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	botInstance.Send(msgResponse)
+}
+
+func formatAuditEntry(entry models.AuditEntry) string {
+	line := fmt.Sprintf("%s | %d | %s", entry.CreatedAt.Local().Format("2006-01-02 15:04"), entry.ActorID, entry.Action)
+	if entry.Target != "" {
+		line += " | " + entry.Target
+	}
+	if entry.OldValue != "" || entry.NewValue != "" {
+		line += fmt.Sprintf(" | %s → %s", shorten(entry.OldValue), shorten(entry.NewValue))
+	}
+	return line
+}
+
+// shorten cuts a value to auditValueLength characters on one line.
+func shorten(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if value == "" {
+		return "-"
+	}
+	runes := []rune(value)
+	if len(runes) > auditValueLength {
+		return string(runes[:auditValueLength]) + "…"
+	}
+	return value
+}
+
+func ExportAuditLog(ctx context.Context, chatID int64, repos *storage.Repos, botInstance messenger.Messenger) {
+	timestamp := time.Now().Format("20060102_150405")
+	filename := fmt.Sprintf("audit_%s.xlsx", timestamp)
+	path := filepath.Join(config.Get().DumpDir, filename)
+
+	entries, err := repos.Audit.All(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting audit entries", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Audit jurnalini olishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	file := xlsx.NewFile()
+	sheet, err := file.AddSheet("Audit")
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating sheet", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Excel fayl yaratishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	row := sheet.AddRow()
+	for _, title := range []string{"ID", "Time", "Actor", "Action", "Target", "Old value", "New value"} {
+		row.AddCell().Value = title
+	}
+	for _, entry := range entries {
+		row := sheet.AddRow()
+		row.AddCell().Value = fmt.Sprint(entry.ID)
+		row.AddCell().Value = entry.CreatedAt.Local().Format("2006-01-02 15:04:05")
+		row.AddCell().Value = fmt.Sprint(entry.ActorID)
+		row.AddCell().Value = entry.Action
+		row.AddCell().Value = entry.Target
+		row.AddCell().Value = entry.OldValue
+		row.AddCell().Value = entry.NewValue
+	}
+
+	if err := file.Save(path); err != nil {
+		slog.ErrorContext(ctx, "Error saving Excel file", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Excel faylini saqlashda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+	defer os.Remove(path)
+
+	fileBytes, err := os.ReadFile(path)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading Excel file", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Excel faylini o'qishda xatolik.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	document := tgbotapi.NewDocumentUpload(chatID, tgbotapi.FileBytes{
+		Name:  filename,
+		Bytes: fileBytes,
+	})
+	if _, err := botInstance.Send(document); err != nil {
+		slog.ErrorContext(ctx, "Error sending Excel file", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Excel faylini yuborishda xatolik.")
+		botInstance.Send(msgResponse)
+		return
+	}
+	audit.Record(ctx, repos.Audit, chatID, audit.ActionExportAudit, fmt.Sprintf("%d entries", len(entries)), "", filename)
+}
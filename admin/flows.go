@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"tgbot/fsm"
+	"tgbot/models"
+	"time"
+)
+
+// promptTTL is how long admin prompts wait for an answer. It is short so that
+// a forgotten prompt does not swallow an unrelated message later.
+const promptTTL = 15 * time.Minute
+
+// BroadcastFlow sends one message to every user.
+var BroadcastFlow = fsm.Flow{
+	Name:       "broadcast",
+	Permission: models.PermissionBroadcast,
+	States: []fsm.State{
+		{
+			Name:   "waiting_for_broadcast_message",
+			TTL:    promptTTL,
+			Prompt: fsm.Ask("Iltimos, yubormoqchi bo'lgan habaringizni kiriting (Bekor qilish uchun /cancel):"),
+			Handle: HandleBroadcastMessage,
+		},
+	},
+	Cancel: ReturnToMenu("Habar yuborish bekor qilindi."),
+}
+
+// ChannelFlow adds a channel users must subscribe to.
+var ChannelFlow = fsm.Flow{
+	Name:       "channel",
+	Permission: models.PermissionManageChannels,
+	States: []fsm.State{
+		{
+			Name:     "waiting_for_channel_link",
+			TTL:      promptTTL,
+			Prompt:   fsm.Ask("Kanal linkini yuboring (masalan, https://t.me/your_channel):"),
+			Validate: requireText,
+			Handle:   HandleChannelLink,
+		},
+	},
+	Cancel: ReturnToMenu("Kanal qo'shish bekor qilindi."),
+}
+
+// AdminsFlow adds admins, changes their roles and removes them.
+var AdminsFlow = fsm.Flow{
+	Name:       "admins",
+	Permission: models.PermissionManageAdmins,
+	States: []fsm.State{
+		{
+			Name:     "waiting_for_admin_id",
+			TTL:      promptTTL,
+			Prompt:   fsm.Ask("Iltimos, yangi admin ID sini va rolini yuboring: \n\n Namuna: 123456789 teacher \n\n Rollar: owner - hammasi, admin - adminlarni boshqarishdan tashqari hammasi, teacher - o'zi yaratgan testlar va statistika, viewer - faqat statistika. Rol ko'rsatilmasa admin beriladi."),
+			Validate: validateAdminGrant,
+			Handle:   HandleAdminAdd,
+		},
+		{
+			Name:     "waiting_for_admin_id_remove",
+			TTL:      promptTTL,
+			Prompt:   fsm.Ask("Iltimos, admin ID sini o'chirish uchun yuboring:"),
+			Validate: validateAdminRemoval,
+			Handle:   HandleAdminRemove,
+		},
+	},
+	Cancel: ReturnToMenu("Bekor qilindi."),
+}
+
+// AttemptResetFlow lets a user retake a test.
+var AttemptResetFlow = fsm.Flow{
+	Name:       "attempt_reset",
+	Permission: models.PermissionUploadTests,
+	States: []fsm.State{
+		{
+			Name:     "waiting_for_attempt_reset",
+			TTL:      promptTTL,
+			Prompt:   fsm.Ask("Iltimos, foydalanuvchi ID si va test ID sini yuboring: \n\n Namuna: 123456789 4"),
+			Validate: validateAttemptReset,
+			Handle:   HandleAttemptReset,
+		},
+	},
+	Cancel: ReturnToMenu("Urinishlarni tiklash bekor qilindi."),
+}
+
+// CertificateTemplateFlow replaces the text printed on certificates.
+var CertificateTemplateFlow = fsm.Flow{
+	Name:       "certificate_template",
+	Permission: models.PermissionManageSettings,
+	States: []fsm.State{
+		{
+			Name:     "waiting_for_certificate_template",
+			TTL:      promptTTL,
+			Prompt:   AskForCertificateTemplate,
+			Validate: validateCertificateTemplate,
+			Handle:   HandleCertificateTemplate,
+		},
+	},
+	Cancel: ReturnToMenu("Shablonni o'zgartirish bekor qilindi."),
+}
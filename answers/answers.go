@@ -0,0 +1,201 @@
+package answers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxQuestions is the highest question number accepted in answers and keys.
+// Results are sized by the highest number given, so it must stay small.
+const MaxQuestions = 500
+
+// lookalikes maps Cyrillic letters to the Latin letters they are commonly typed for.
+var lookalikes = map[rune]rune{
+	'а': 'a', 'в': 'b', 'с': 'c', 'е': 'e', 'о': 'o', 'р': 'p',
+	'х': 'x', 'у': 'y', 'к': 'k', 'м': 'm', 'т': 't', 'н': 'h',
+}
+
+// numberedAnswer matches "1a", "1ac", "1-a", "2) b", "3=3.14" and "4-paris".
+// Numeric answers need a separator so that "12" is not read as "1-2".
+var numberedAnswer = regexp.MustCompile(`(\d+)\s*(?:[-.):=]\s*(-?\d+(?:\.\d+)?|\pL+)|(\pL+))`)
+
+// Normalize lowercases the text and replaces Cyrillic look-alikes with Latin letters.
+func Normalize(text string) string {
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToLower(r)
+		if latin, ok := lookalikes[r]; ok {
+			return latin
+		}
+		return r
+	}, text)
+}
+
+// Parse splits an answer sheet into one answer per question. It accepts plain
+// letter sequences ("abcd", "a b c d", one per line), numbered answers on one
+// line ("1a 2b", "1-a, 2-b", "1ac 2=3.14 3-paris") and numbered answers one per
+// line ("1) new york"). Questions skipped in numbered answers are left empty.
+func Parse(text string) ([]string, error) {
+	text = Normalize(strings.TrimSpace(text))
+	if text == "" {
+		return nil, fmt.Errorf("javoblar topilmadi")
+	}
+
+	if strings.IndexFunc(text, unicode.IsDigit) < 0 {
+		return parsePlain(text)
+	}
+
+	byNumber, err := parseNumbered(text)
+	var numberErr numberError
+	if err != nil && !errors.As(err, &numberErr) {
+		// Answers containing spaces or commas only fit the one-per-line format
+		if byLine, lineErr := parseNumberedLines(text); lineErr == nil {
+			byNumber, err = byLine, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	maxNumber := 0
+	for number := range byNumber {
+		if number > maxNumber {
+			maxNumber = number
+		}
+	}
+
+	result := make([]string, maxNumber)
+	for number, answer := range byNumber {
+		result[number-1] = answer
+	}
+	return result, nil
+}
+
+func parsePlain(text string) ([]string, error) {
+	var result []string
+	for _, r := range text {
+		switch {
+		case isSeparator(r):
+			continue
+		case r >= 'a' && r <= 'z':
+			result = append(result, string(r))
+		default:
+			return nil, fmt.Errorf("noma'lum belgi %q", r)
+		}
+	}
+	return result, nil
+}
+
+func parseNumbered(text string) (map[int]string, error) {
+	byNumber := make(map[int]string)
+
+	last := 0
+	for _, m := range numberedAnswer.FindAllStringSubmatchIndex(text, -1) {
+		if rest := strings.TrimFunc(text[last:m[0]], isSeparator); rest != "" {
+			return nil, fmt.Errorf("tushunarsiz qism %q", rest)
+		}
+		last = m[1]
+
+		answer := ""
+		if m[4] >= 0 {
+			answer = text[m[4]:m[5]]
+		} else {
+			answer = text[m[6]:m[7]]
+		}
+		if err := addNumbered(byNumber, text[m[2]:m[3]], answer); err != nil {
+			return nil, err
+		}
+	}
+	if rest := strings.TrimFunc(text[last:], isSeparator); rest != "" {
+		return nil, fmt.Errorf("tushunarsiz qism %q", rest)
+	}
+
+	return byNumber, nil
+}
+
+// parseNumberedLines reads one numbered answer per line, taking the whole rest
+// of the line as the answer.
+func parseNumberedLines(text string) (map[int]string, error) {
+	byNumber := make(map[int]string)
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		digits := strings.IndexFunc(line, func(r rune) bool { return !unicode.IsDigit(r) })
+		if digits <= 0 {
+			return nil, fmt.Errorf("raqamlanmagan qator %q", line)
+		}
+
+		rest := strings.TrimLeft(line[digits:], " \t")
+		spaced := len(rest) < len(line[digits:])
+		if rest != "" && strings.ContainsRune("-.):=", rune(rest[0])) {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !spaced && (rest == "" || !unicode.IsLetter([]rune(rest)[0])) {
+			return nil, fmt.Errorf("tushunarsiz qator %q", line)
+		}
+		if rest == "" {
+			return nil, fmt.Errorf("%s-savol javobi bo'sh", line[:digits])
+		}
+
+		if err := addNumbered(byNumber, line[:digits], rest); err != nil {
+			return nil, err
+		}
+	}
+
+	return byNumber, nil
+}
+
+// numberError is a question number out of range or given twice. Reading the
+// text one answer per line would hide it inside an answer, so Parse reports it.
+type numberError struct {
+	error
+}
+
+func addNumbered(byNumber map[int]string, numberText, answer string) error {
+	number, err := strconv.Atoi(numberText)
+	if err != nil || number < 1 || number > MaxQuestions {
+		return numberError{fmt.Errorf("noto'g'ri savol raqami %q", numberText)}
+	}
+	if _, exists := byNumber[number]; exists {
+		return numberError{fmt.Errorf("%d-savol ikki marta berilgan", number)}
+	}
+	byNumber[number] = answer
+	return nil
+}
+
+func isSeparator(r rune) bool {
+	return unicode.IsSpace(r) || r == ',' || r == ';'
+}
+
+// Validate checks that every question of a key with keyLen questions is answered.
+func Validate(given []string, keyLen int) error {
+	if len(given) > keyLen {
+		return fmt.Errorf("testda %d ta savol bor, siz %d ta javob yubordingiz", keyLen, len(given))
+	}
+
+	var missing []string
+	for i := 0; i < keyLen; i++ {
+		if i >= len(given) || given[i] == "" {
+			missing = append(missing, strconv.Itoa(i+1))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("quyidagi savollarga javob berilmagan: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Format renders the answers one per line as "1) a" for confirmation and storage.
+func Format(given []string) string {
+	lines := make([]string, len(given))
+	for i, answer := range given {
+		lines[i] = fmt.Sprintf("%d) %s", i+1, answer)
+	}
+	return strings.Join(lines, "\n")
+}
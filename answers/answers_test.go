@@ -0,0 +1,64 @@
+package answers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "plain", text: "abcd", want: []string{"a", "b", "c", "d"}},
+		{name: "plain spaced", text: "a b, c", want: []string{"a", "b", "c"}},
+		{name: "cyrillic", text: "аbс", want: []string{"a", "b", "c"}},
+		{name: "numbered", text: "1a 2b 3c", want: []string{"a", "b", "c"}},
+		{name: "separators", text: "1-a, 2) b; 3=3.14", want: []string{"a", "b", "3.14"}},
+		{name: "skipped", text: "1a 3c", want: []string{"a", "", "c"}},
+		{name: "lines", text: "1) new york\n2) b", want: []string{"new york", "b"}},
+		{name: "lines with numbers", text: "1) 3 apples\n2) b", want: []string{"3 apples", "b"}},
+		{name: "last allowed", text: "500a", want: append(make([]string, 499), "a")},
+		{name: "empty", text: "  ", wantErr: true},
+		{name: "unknown character", text: "ab!", wantErr: true},
+		{name: "zero", text: "0a 1b", wantErr: true},
+		{name: "duplicate", text: "1a 2b 1c", wantErr: true},
+		{name: "duplicate lines", text: "1) a\n1) b c", wantErr: true},
+		{name: "too large", text: "1a 501b", wantErr: true},
+		{name: "huge", text: "1a 2000000000b", wantErr: true},
+		{name: "out of int range", text: "99999999999999999999a", wantErr: true},
+		{name: "huge line", text: "1) a\n2000000000) b c", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %q, want error", tt.text, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.text, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate([]string{"a", "b"}, 2); err != nil {
+		t.Errorf("complete answers: %v", err)
+	}
+	if err := Validate([]string{"a", ""}, 2); err == nil {
+		t.Error("missing answer accepted")
+	}
+	if err := Validate([]string{"a", "b", "c"}, 2); err == nil {
+		t.Error("extra answer accepted")
+	}
+}
@@ -0,0 +1,351 @@
+package answers
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of a question in the answer key.
+type Kind int
+
+const (
+	// Single is a choice question with one correct option: a
+	Single Kind = iota
+	// Multiple is a choice question with several correct options: [a,c]
+	Multiple
+	// Numeric is a number with an optional tolerance: =3.14~0.01
+	Numeric
+	// Text is a short text answer with accepted variants: "paris|parij"
+	Text
+)
+
+// Question is one item of the answer key.
+type Question struct {
+	Kind Kind
+	// Choices holds the correct options of choice questions in sorted order.
+	Choices   []string
+	Number    float64
+	Tolerance float64
+	Variants  []string
+	Weight    float64
+}
+
+// Key is a parsed answer key with the scoring scheme of the test.
+type Key struct {
+	Questions []Question
+	// Penalty is subtracted for every wrong answer.
+	Penalty float64
+}
+
+// Result is a graded submission.
+type Result struct {
+	Correct  []bool
+	Score    float64
+	MaxScore float64
+}
+
+var typedKeyLine = regexp.MustCompile(`^(\d+)(?:\s*[-.):]\s*|\s+)(\S.*)$|^(\d+)([\pL\["=].*)$`)
+
+// ParseKey parses an answer key. Keys made of letters only use the same formats
+// as submissions, where several letters for one numbered question ("3ac") make
+// a multiple choice question. Keys with numeric or text questions list one
+// numbered question per line:
+//
+//  1. a
+//  2. [a,c]
+//  3. =3.14~0.01
+//  4. "paris|parij"
+//
+// Besides the questions the key may contain directive lines:
+//
+//	#ball 1-10=1.1; 11-20=2.1; 21-30=3.1
+//	#jarima 0.25
+func ParseKey(text string) (Key, error) {
+	var (
+		key        Key
+		answerText []string
+		weightSpec string
+	)
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#") {
+			answerText = append(answerText, line)
+			continue
+		}
+
+		fields := strings.SplitN(strings.TrimPrefix(trimmed, "#"), " ", 2)
+		value := ""
+		if len(fields) == 2 {
+			value = strings.TrimSpace(fields[1])
+		}
+		switch strings.ToLower(fields[0]) {
+		case "ball":
+			weightSpec = value
+		case "jarima":
+			penalty, err := strconv.ParseFloat(value, 64)
+			if err != nil || penalty < 0 {
+				return key, fmt.Errorf("noto'g'ri jarima %q", value)
+			}
+			key.Penalty = penalty
+		default:
+			return key, fmt.Errorf("noma'lum buyruq %q", trimmed)
+		}
+	}
+
+	var err error
+	key.Questions, err = parseQuestions(strings.Join(answerText, "\n"))
+	if err != nil {
+		return key, err
+	}
+
+	if weightSpec != "" {
+		if err := parseWeights(weightSpec, key.Questions); err != nil {
+			return key, err
+		}
+	}
+
+	return key, nil
+}
+
+func parseQuestions(text string) ([]Question, error) {
+	if !strings.ContainsAny(text, `["=`) {
+		items, err := Parse(text)
+		if err != nil {
+			return nil, err
+		}
+
+		questions := make([]Question, len(items))
+		for i, item := range items {
+			if item == "" {
+				return nil, fmt.Errorf("%d-savol javobi berilmagan", i+1)
+			}
+			if questions[i], err = parseQuestion(item); err != nil {
+				return nil, fmt.Errorf("%d-savol: %v", i+1, err)
+			}
+		}
+		return questions, nil
+	}
+
+	byNumber := make(map[int]Question)
+	maxNumber := 0
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		m := typedKeyLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("raqamlanmagan qator %q", line)
+		}
+		numberText, item := m[1], m[2]
+		if numberText == "" {
+			numberText, item = m[3], m[4]
+		}
+
+		number, err := strconv.Atoi(numberText)
+		if err != nil || number < 1 || number > MaxQuestions {
+			return nil, fmt.Errorf("noto'g'ri savol raqami %q", numberText)
+		}
+		if _, exists := byNumber[number]; exists {
+			return nil, fmt.Errorf("%d-savol ikki marta berilgan", number)
+		}
+		if byNumber[number], err = parseQuestion(item); err != nil {
+			return nil, fmt.Errorf("%d-savol: %v", number, err)
+		}
+		if number > maxNumber {
+			maxNumber = number
+		}
+	}
+
+	questions := make([]Question, maxNumber)
+	for i := range questions {
+		question, exists := byNumber[i+1]
+		if !exists {
+			return nil, fmt.Errorf("%d-savol javobi berilmagan", i+1)
+		}
+		questions[i] = question
+	}
+	return questions, nil
+}
+
+func parseQuestion(item string) (Question, error) {
+	item = Normalize(strings.TrimSpace(item))
+	question := Question{Weight: 1}
+
+	switch {
+	case strings.HasPrefix(item, "[") && strings.HasSuffix(item, "]"):
+		choices, ok := choiceSet(item[1 : len(item)-1])
+		if !ok || len(choices) == 0 {
+			return question, fmt.Errorf("noto'g'ri variantlar %q", item)
+		}
+		question.Kind = Multiple
+		question.Choices = choices
+	case strings.HasPrefix(item, "="):
+		numberText, toleranceText, hasTolerance := strings.Cut(item[1:], "~")
+		number, err := parseNumber(numberText)
+		if err != nil {
+			return question, fmt.Errorf("noto'g'ri son %q", numberText)
+		}
+		question.Kind = Numeric
+		question.Number = number
+		if hasTolerance {
+			if question.Tolerance, err = parseNumber(toleranceText); err != nil || question.Tolerance < 0 {
+				return question, fmt.Errorf("noto'g'ri xatolik chegarasi %q", toleranceText)
+			}
+		}
+	case strings.HasPrefix(item, `"`) && strings.HasSuffix(item, `"`) && len(item) > 1:
+		for _, variant := range strings.Split(item[1:len(item)-1], "|") {
+			if variant = normalizeText(variant); variant != "" {
+				question.Variants = append(question.Variants, variant)
+			}
+		}
+		if len(question.Variants) == 0 {
+			return question, fmt.Errorf("bo'sh matnli javob")
+		}
+		question.Kind = Text
+	default:
+		choices, ok := choiceSet(item)
+		if !ok || len(choices) == 0 {
+			return question, fmt.Errorf("noto'g'ri javob %q", item)
+		}
+		question.Kind = Single
+		if len(choices) > 1 {
+			question.Kind = Multiple
+		}
+		question.Choices = choices
+	}
+
+	return question, nil
+}
+
+// choiceSet returns the sorted distinct letters of a choice answer like "ac" or "a, c".
+func choiceSet(text string) ([]string, bool) {
+	seen := make(map[string]bool)
+	var choices []string
+	for _, r := range text {
+		switch {
+		case isSeparator(r):
+			continue
+		case r >= 'a' && r <= 'z':
+			if !seen[string(r)] {
+				seen[string(r)] = true
+				choices = append(choices, string(r))
+			}
+		default:
+			return nil, false
+		}
+	}
+	sort.Strings(choices)
+	return choices, true
+}
+
+func parseNumber(text string) (float64, error) {
+	return strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(text), ",", "."), 64)
+}
+
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(Normalize(text)), " ")
+}
+
+// Matches reports whether the given answer is correct for the question.
+func (q Question) Matches(answer string) bool {
+	answer = Normalize(strings.TrimSpace(answer))
+
+	switch q.Kind {
+	case Single, Multiple:
+		choices, ok := choiceSet(answer)
+		if !ok || len(choices) != len(q.Choices) {
+			return false
+		}
+		for i := range choices {
+			if choices[i] != q.Choices[i] {
+				return false
+			}
+		}
+		return true
+	case Numeric:
+		number, err := parseNumber(strings.TrimPrefix(answer, "="))
+		return err == nil && math.Abs(number-q.Number) <= q.Tolerance+1e-9
+	case Text:
+		answer = normalizeText(answer)
+		for _, variant := range q.Variants {
+			if answer == variant {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseWeights applies ranges like "1-10=1.1; 11=2" to the question weights.
+func parseWeights(spec string, questions []Question) error {
+	for _, part := range strings.FieldsFunc(spec, func(r rune) bool { return r == ';' || r == ',' }) {
+		rangeText, weightText, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return fmt.Errorf("noto'g'ri ball %q", part)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(weightText), 64)
+		if err != nil || weight <= 0 {
+			return fmt.Errorf("noto'g'ri ball %q", part)
+		}
+
+		fromText, toText, isRange := strings.Cut(strings.TrimSpace(rangeText), "-")
+		if !isRange {
+			toText = fromText
+		}
+		from, err1 := strconv.Atoi(strings.TrimSpace(fromText))
+		to, err2 := strconv.Atoi(strings.TrimSpace(toText))
+		if err1 != nil || err2 != nil || from < 1 || to < from || to > len(questions) {
+			return fmt.Errorf("noto'g'ri savollar oralig'i %q", rangeText)
+		}
+
+		for i := from; i <= to; i++ {
+			questions[i-1].Weight = weight
+		}
+	}
+	return nil
+}
+
+// MaxScore is the score of a fully correct submission.
+func (k Key) MaxScore() float64 {
+	total := 0.0
+	for _, question := range k.Questions {
+		total += question.Weight
+	}
+	return total
+}
+
+// Grade scores the given answers against the key. The score never drops below zero.
+func Grade(key Key, given []string) Result {
+	result := Result{
+		Correct:  make([]bool, len(key.Questions)),
+		MaxScore: key.MaxScore(),
+	}
+
+	for i, question := range key.Questions {
+		if i < len(given) && question.Matches(given[i]) {
+			result.Correct[i] = true
+			result.Score += question.Weight
+		} else if i < len(given) && given[i] != "" {
+			result.Score -= key.Penalty
+		}
+	}
+
+	result.Score = math.Max(0, math.Round(result.Score*100)/100)
+	return result
+}
+
+// Percent is the score as a percentage of the maximum score.
+func (r Result) Percent() float64 {
+	if r.MaxScore == 0 {
+		return 0
+	}
+	return r.Score / r.MaxScore * 100
+}
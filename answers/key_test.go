@@ -0,0 +1,245 @@
+package answers
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseQuestions(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []Kind
+		wantErr bool
+	}{
+		{name: "letters", text: "abc", want: []Kind{Single, Single, Single}},
+		{name: "multiple letters", text: "1a 2ac", want: []Kind{Single, Multiple}},
+		{name: "typed", text: "1. a\n2. [a,c]\n3. =3.14~0.01\n4. \"paris|parij\"", want: []Kind{Single, Multiple, Numeric, Text}},
+		{name: "typed without separator", text: "1a\n2=5", want: []Kind{Single, Numeric}},
+		{name: "gap", text: "1a 3c", wantErr: true},
+		{name: "typed gap", text: "1. a\n3. =1", wantErr: true},
+		{name: "zero", text: "0a 1b", wantErr: true},
+		{name: "typed zero", text: "0. =1", wantErr: true},
+		{name: "duplicate", text: "1a 1b", wantErr: true},
+		{name: "typed duplicate", text: "1. =1\n1. =2", wantErr: true},
+		{name: "huge", text: "1a 2000000000b", wantErr: true},
+		{name: "typed huge", text: "1. =1\n2000000000. =2", wantErr: true},
+		{name: "typed out of int range", text: "99999999999999999999. =1", wantErr: true},
+		{name: "unnumbered line", text: "1. =1\nb", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQuestions(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseQuestions(%q) = %+v, want error", tt.text, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseQuestions(%q): %v", tt.text, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseQuestions(%q) has %d questions, want %d", tt.text, len(got), len(tt.want))
+			}
+			for i, question := range got {
+				if question.Kind != tt.want[i] {
+					t.Errorf("question %d kind = %v, want %v", i+1, question.Kind, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGradeWithPenalty(t *testing.T) {
+	key, err := ParseKey("abc\n#jarima 0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := Grade(key, []string{"a", "c", "c"})
+	if result.Score != 1.5 || result.MaxScore != 3 {
+		t.Errorf("Grade = %v of %v, want 1.5 of 3", result.Score, result.MaxScore)
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		want    []float64
+		wantErr bool
+	}{
+		{name: "default", key: "abc", want: []float64{1, 1, 1}},
+		{name: "ranges", key: "abcd\n#ball 1-2=1.1; 3-4=2.1", want: []float64{1.1, 1.1, 2.1, 2.1}},
+		{name: "single question", key: "abc\n#ball 2=3", want: []float64{1, 3, 1}},
+		{name: "comma separated", key: "abc\n#ball 1=2, 3=0.5", want: []float64{2, 1, 0.5}},
+		{name: "later range wins", key: "abc\n#ball 1-3=2; 2=5", want: []float64{2, 5, 2}},
+		{name: "past the last question", key: "abc\n#ball 1-4=2", wantErr: true},
+		{name: "reversed range", key: "abc\n#ball 3-1=2", wantErr: true},
+		{name: "zero weight", key: "abc\n#ball 1=0", wantErr: true},
+		{name: "negative weight", key: "abc\n#ball 1=-1", wantErr: true},
+		{name: "missing weight", key: "abc\n#ball 1-3", wantErr: true},
+		{name: "negative penalty", key: "abc\n#jarima -1", wantErr: true},
+		{name: "unknown directive", key: "abc\n#bonus 1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey(tt.key)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseKey(%q) = %+v, want error", tt.key, key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKey(%q): %v", tt.key, err)
+			}
+			if len(key.Questions) != len(tt.want) {
+				t.Fatalf("ParseKey(%q) has %d questions, want %d", tt.key, len(key.Questions), len(tt.want))
+			}
+			for i, question := range key.Questions {
+				if question.Weight != tt.want[i] {
+					t.Errorf("question %d weight = %v, want %v", i+1, question.Weight, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGradeWeighted(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		given       []string
+		wantScore   float64
+		wantMax     float64
+		wantPercent float64
+	}{
+		{name: "all correct", key: "abcd\n#ball 1-2=1.1; 3-4=2.1", given: []string{"a", "b", "c", "d"}, wantScore: 6.4, wantMax: 6.4, wantPercent: 100},
+		{name: "heavy questions only", key: "abcd\n#ball 1-2=1.1; 3-4=2.1", given: []string{"b", "a", "c", "d"}, wantScore: 4.2, wantMax: 6.4, wantPercent: 65.625},
+		{name: "penalty with weights", key: "abcd\n#ball 1-2=1.1; 3-4=2.1\n#jarima 0.25", given: []string{"a", "a", "c", "a"}, wantScore: 2.7, wantMax: 6.4, wantPercent: 2.7 / 6.4 * 100},
+		{name: "unanswered is not penalised", key: "abcd\n#ball 4=3\n#jarima 0.5", given: []string{"a", "", "", "d"}, wantScore: 4, wantMax: 6, wantPercent: 4.0 / 6 * 100},
+		{name: "never below zero", key: "ab\n#ball 1-2=0.5\n#jarima 1", given: []string{"c", "c"}, wantScore: 0, wantMax: 1, wantPercent: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := Grade(key, tt.given)
+			if math.Abs(result.Score-tt.wantScore) > 1e-9 || math.Abs(result.MaxScore-tt.wantMax) > 1e-9 {
+				t.Errorf("Grade = %v of %v, want %v of %v", result.Score, result.MaxScore, tt.wantScore, tt.wantMax)
+			}
+			if math.Abs(result.Percent()-tt.wantPercent) > 1e-9 {
+				t.Errorf("Percent = %v, want %v", result.Percent(), tt.wantPercent)
+			}
+		})
+	}
+
+	if percent := (Result{}).Percent(); percent != 0 {
+		t.Errorf("Percent without a maximum = %v, want 0", percent)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		key    string
+		answer string
+		want   bool
+	}{
+		// Single and multiple choice compare sets of letters
+		{key: "a", answer: "a", want: true},
+		{key: "a", answer: "A", want: true},
+		{key: "a", answer: "а", want: true}, // Cyrillic
+		{key: "a", answer: "b", want: false},
+		{key: "a", answer: "ab", want: false},
+		{key: "a", answer: "", want: false},
+		{key: "[a,c]", answer: "ac", want: true},
+		{key: "[a,c]", answer: "ca", want: true},
+		{key: "[a,c]", answer: "c, a", want: true},
+		{key: "[a,c]", answer: "acc", want: true},
+		{key: "[a,c]", answer: "a", want: false},
+		{key: "[a,c]", answer: "abc", want: false},
+		{key: "ac", answer: "CA", want: true},
+		{key: "[a,c]", answer: "a1", want: false},
+
+		// Numbers match within the tolerance, with either decimal separator
+		{key: "=3.14", answer: "3.14", want: true},
+		{key: "=3.14", answer: "3,14", want: true},
+		{key: "=3.14", answer: "=3.14", want: true},
+		{key: "=3.14", answer: "3.140", want: true},
+		{key: "=3.14", answer: "3.15", want: false},
+		{key: "=3,14", answer: "3.14", want: true},
+		{key: "=3.14~0.01", answer: "3.15", want: true},
+		{key: "=3.14~0.01", answer: "3.13", want: true},
+		{key: "=3.14~0.01", answer: "3.16", want: false},
+		{key: "=3.14~0,01", answer: "3,15", want: true},
+		{key: "=-2", answer: "-2", want: true},
+		{key: "=-2", answer: "2", want: false},
+		{key: "=10", answer: "o'n", want: false},
+		{key: "=10", answer: "", want: false},
+
+		// Text answers match any variant, ignoring case and extra spaces
+		{key: `"paris|parij"`, answer: "paris", want: true},
+		{key: `"paris|parij"`, answer: "Parij", want: true},
+		{key: `"paris|parij"`, answer: "  PARIS ", want: true},
+		{key: `"paris|parij"`, answer: "london", want: false},
+		{key: `"new york"`, answer: "new   york", want: true},
+		{key: `"new york"`, answer: "newyork", want: false},
+		{key: `"Toshkent"`, answer: "toshkent", want: true},
+		{key: `"москва"`, answer: "Москва", want: true},
+		{key: `"paris|"`, answer: "", want: false},
+	}
+
+	for _, tt := range tests {
+		question, err := parseQuestion(tt.key)
+		if err != nil {
+			t.Fatalf("parseQuestion(%q): %v", tt.key, err)
+		}
+		if got := question.Matches(tt.answer); got != tt.want {
+			t.Errorf("%s matches %q = %v, want %v", tt.key, tt.answer, got, tt.want)
+		}
+	}
+}
+
+func TestParseQuestion(t *testing.T) {
+	tests := []struct {
+		item    string
+		want    Question
+		wantErr bool
+	}{
+		{item: "b", want: Question{Kind: Single, Choices: []string{"b"}, Weight: 1}},
+		{item: "[c,a,c]", want: Question{Kind: Multiple, Choices: []string{"a", "c"}, Weight: 1}},
+		{item: "=3,5~0,5", want: Question{Kind: Numeric, Number: 3.5, Tolerance: 0.5, Weight: 1}},
+		{item: `"Paris | PARIJ"`, want: Question{Kind: Text, Variants: []string{"paris", "parij"}, Weight: 1}},
+		{item: "[]", wantErr: true},
+		{item: "[a,1]", wantErr: true},
+		{item: "=abc", wantErr: true},
+		{item: "=1~-1", wantErr: true},
+		{item: `"|"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseQuestion(tt.item)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseQuestion(%q) = %+v, want error", tt.item, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseQuestion(%q): %v", tt.item, err)
+			continue
+		}
+		if got.Kind != tt.want.Kind || got.Number != tt.want.Number || got.Tolerance != tt.want.Tolerance ||
+			got.Weight != tt.want.Weight || strings.Join(got.Choices, ",") != strings.Join(tt.want.Choices, ",") ||
+			strings.Join(got.Variants, "|") != strings.Join(tt.want.Variants, "|") {
+			t.Errorf("parseQuestion(%q) = %+v, want %+v", tt.item, got, tt.want)
+		}
+	}
+}
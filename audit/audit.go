@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"tgbot/models"
+	"tgbot/storage"
+)
+
+// Actions written to the audit log.
+const (
+	ActionDenied                 = "denied"
+	ActionAddChannel             = "add_channel"
+	ActionDeleteChannel          = "delete_channel"
+	ActionAddAdmin               = "add_admin"
+	ActionRemoveAdmin            = "remove_admin"
+	ActionCreateTest             = "create_test"
+	ActionSetTestSubject         = "set_test_subject"
+	ActionSetTestAttempts        = "set_test_attempts"
+	ActionSetTestVisibility      = "set_test_visibility"
+	ActionSetTestSchedule        = "set_test_schedule"
+	ActionUploadTestFile         = "upload_test_file"
+	ActionSetAnswerKey           = "set_answer_key"
+	ActionSetTestStatus          = "set_test_status"
+	ActionPublishResults         = "publish_results"
+	ActionResetAttempts          = "reset_attempts"
+	ActionSetCertificateTemplate = "set_certificate_template"
+	ActionBroadcast              = "broadcast"
+	ActionExportDB               = "export_db"
+	ActionExportUsers            = "export_users"
+	ActionExportAudit            = "export_audit"
+)
+
+// Record writes an entry to the audit log. A failed write is only logged so
+// that the action itself is never undone by it.
+func Record(ctx context.Context, repo storage.AuditRepo, actorID int64, action, target, oldValue, newValue string) {
+	entry := models.AuditEntry{
+		ActorID:  actorID,
+		Action:   action,
+		Target:   target,
+		OldValue: oldValue,
+		NewValue: newValue,
+	}
+	if err := repo.Add(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "Error writing audit entry", "action", action, "actor_id", actorID, "target", target, "err", err)
+	}
+}
+
+// Test is the target of actions on a test.
+func Test(testID int) string {
+	return fmt.Sprintf("test %d", testID)
+}
+
+// User is the target of actions on a user or admin.
+func User(userID int64) string {
+	return fmt.Sprintf("user %d", userID)
+}
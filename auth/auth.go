@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"tgbot/audit"
+	"tgbot/messenger"
+	"tgbot/models"
+	"tgbot/state"
+	"tgbot/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Guard lets only admins whose role has the needed permission send admin
+// commands, press admin buttons and use admin flows. It runs before any
+// handler, so handlers do not need to check.
+type Guard struct {
+	Repos *storage.Repos
+	Bot   messenger.Messenger
+	// Commands maps message texts to the permission they need. An empty
+	// permission lets any admin role send the text.
+	Commands map[string]string
+	// CallbackPrefixes maps callback data prefixes of admin buttons to the
+	// permission they need.
+	CallbackPrefixes map[string]string
+	// StatePermission returns the permission needed in a conversation state,
+	// or "" for states open to everyone, and false for unknown states.
+	StatePermission func(name string) (string, bool)
+}
+
+// Request is an update that needs an admin role.
+type Request struct {
+	// Action is the target of the audit entry written when it is denied.
+	Action string
+	// Permission is what the role must grant; empty means any admin role.
+	Permission string
+	// State is set when the request is a message sent in an admin flow.
+	State string
+}
+
+// Wrap returns a handler that calls next only for updates the sender is
+// allowed to make.
+func (g *Guard) Wrap(next func(context.Context, tgbotapi.Update)) func(context.Context, tgbotapi.Update) {
+	return func(ctx context.Context, update tgbotapi.Update) {
+		if update.Message != nil {
+			ctx = state.Resolve(ctx, update.Message.Chat.ID)
+		}
+		req, restricted := g.Check(ctx, update)
+		if !restricted {
+			next(ctx, update)
+			return
+		}
+
+		userID := senderID(update)
+		role, err := g.Repos.Admins.Role(ctx, userID)
+		if err != nil {
+			if err != storage.ErrNotFound {
+				slog.ErrorContext(ctx, "Error getting admin role", "user_id", userID, "err", err)
+			}
+			g.deny(ctx, update, userID, req, "Siz admin emassiz.")
+			return
+		}
+		if req.Permission != "" && !models.RoleCan(role, req.Permission) {
+			g.deny(ctx, update, userID, req, "Sizda bu amal uchun ruxsat yo'q.")
+			return
+		}
+		next(ctx, update)
+	}
+}
+
+// Check reports whether the update needs an admin role and which.
+func (g *Guard) Check(ctx context.Context, update tgbotapi.Update) (Request, bool) {
+	if update.Message != nil {
+		// In a conversation the text is the answer to a question, not a
+		// command. Unknown states are reset by the router, which then handles
+		// the text as if there were no conversation, so it is checked as such.
+		if current := state.Current(ctx, update.Message.Chat.ID); current.Name != "" {
+			if permission, known := g.StatePermission(current.Name); known {
+				if permission == "" {
+					return Request{}, false
+				}
+				return Request{Action: "state " + current.Name, Permission: permission, State: current.Name}, true
+			}
+		}
+
+		text := strings.TrimSpace(update.Message.Text)
+		if permission, ok := g.Commands[text]; ok {
+			return Request{Action: "command " + text, Permission: permission}, true
+		}
+		return Request{}, false
+	}
+
+	if update.CallbackQuery != nil {
+		for prefix, permission := range g.CallbackPrefixes {
+			if strings.HasPrefix(update.CallbackQuery.Data, prefix) {
+				return Request{Action: "callback " + update.CallbackQuery.Data, Permission: permission}, true
+			}
+		}
+	}
+	return Request{}, false
+}
+
+func (g *Guard) deny(ctx context.Context, update tgbotapi.Update, userID int64, req Request, reason string) {
+	slog.WarnContext(ctx, "Denied admin action", "action", req.Action, "user_id", userID, "reason", reason)
+	audit.Record(ctx, g.Repos.Audit, userID, audit.ActionDenied, req.Action, "", reason)
+
+	if update.Message != nil {
+		chatID := update.Message.Chat.ID
+		// Someone who lost their role mid-flow must not stay stuck in it
+		if req.State != "" {
+			state.Delete(ctx, chatID)
+		}
+		msgResponse := tgbotapi.NewMessage(chatID, reason)
+		g.Bot.Send(msgResponse)
+		return
+	}
+
+	if update.CallbackQuery != nil {
+		g.Bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, reason))
+	}
+}
+
+func senderID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.From != nil:
+		return int64(update.Message.From.ID)
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return int64(update.CallbackQuery.From.ID)
+	}
+	return 0
+}
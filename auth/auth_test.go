@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tgbot/admin"
+	"tgbot/fsm"
+	"tgbot/messenger"
+	"tgbot/models"
+	"tgbot/state"
+	"tgbot/storage"
+	"tgbot/telegramtest"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	buttonBroadcast = "Xabar yuborish"
+	userID          = 7
+)
+
+func newGuard(t *testing.T) (*Guard, *telegramtest.Server) {
+	t.Helper()
+	server := telegramtest.NewServer()
+	bot, err := server.Bot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Use(state.NewMemoryStore())
+
+	guard := &Guard{
+		Repos:    storage.NewMemoryRepos(),
+		Bot:      messenger.NewTelegram(bot, time.Second),
+		Commands: map[string]string{buttonBroadcast: models.PermissionBroadcast},
+		StatePermission: func(name string) (string, bool) {
+			switch name {
+			case "waiting_for_full_name":
+				return "", true
+			case "waiting_for_broadcast_message":
+				return models.PermissionBroadcast, true
+			}
+			return "", false
+		},
+	}
+	return guard, server
+}
+
+// handled reports whether the guard let the update through.
+func handled(guard *Guard, update tgbotapi.Update) bool {
+	called := false
+	guard.Wrap(func(context.Context, tgbotapi.Update) { called = true })(context.Background(), update)
+	return called
+}
+
+func TestGuardChecksCommandsOutsideKnownStates(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  string
+		text   string
+		wantOK bool
+	}{
+		{name: "no state", text: buttonBroadcast, wantOK: false},
+		{name: "unknown state", state: "removed_state", text: buttonBroadcast, wantOK: false},
+		{name: "open state answer", state: "waiting_for_full_name", text: buttonBroadcast, wantOK: true},
+		{name: "admin state", state: "waiting_for_broadcast_message", text: "Salom", wantOK: false},
+		{name: "plain text", text: "Salom", wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard, server := newGuard(t)
+			ctx := context.Background()
+			if tt.state != "" {
+				state.Set(ctx, userID, tt.state, nil, time.Hour)
+			}
+
+			if got := handled(guard, server.Text(userID, tt.text)); got != tt.wantOK {
+				t.Errorf("handled = %v, want %v", got, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGuardAllowsPermittedAdmin(t *testing.T) {
+	guard, server := newGuard(t)
+	if err := guard.Repos.Admins.Add(context.Background(), userID, models.RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+	if !handled(guard, server.Text(userID, buttonBroadcast)) {
+		t.Error("owner was denied")
+	}
+}
+
+func TestResolvedStateOutlivesExpiry(t *testing.T) {
+	state.Use(state.NewMemoryStore())
+	ctx := context.Background()
+	state.Set(ctx, userID, "waiting_for_full_name", nil, time.Hour)
+
+	ctx = state.Resolve(ctx, userID)
+	state.Delete(ctx, userID)
+
+	if got := state.Current(ctx, userID).Name; got != "waiting_for_full_name" {
+		t.Errorf("Current = %q, want the resolved state", got)
+	}
+	if got := state.Current(context.Background(), userID).Name; got != "" {
+		t.Errorf("Current without resolving = %q, want none", got)
+	}
+}
+
+func TestGuardRoles(t *testing.T) {
+	const none = ""
+	router := fsm.NewRouter(admin.BroadcastFlow, admin.ChannelFlow, admin.AdminsFlow, admin.AttemptResetFlow, admin.CertificateTemplateFlow)
+
+	type action struct {
+		name   string
+		update func(server *telegramtest.Server) tgbotapi.Update
+		state  string
+	}
+	text := func(s string) func(*telegramtest.Server) tgbotapi.Update {
+		return func(server *telegramtest.Server) tgbotapi.Update { return server.Text(userID, s) }
+	}
+	press := func(data string) func(*telegramtest.Server) tgbotapi.Update {
+		return func(server *telegramtest.Server) tgbotapi.Update { return server.Callback(userID, 1, data) }
+	}
+
+	// Each action lists the roles allowed to take it
+	tests := []struct {
+		action  action
+		allowed []string
+	}{
+		{action{name: "admin menu", update: text("/admin")}, []string{models.RoleOwner, models.RoleAdmin, models.RoleTeacher, models.RoleViewer}},
+		{action{name: "statistics", update: text(admin.ButtonStatistics)}, []string{models.RoleOwner, models.RoleAdmin, models.RoleTeacher, models.RoleViewer}},
+		{action{name: "create test", update: text(admin.ButtonCreateTest)}, []string{models.RoleOwner, models.RoleAdmin, models.RoleTeacher}},
+		{action{name: "test list", update: text(admin.ButtonTests)}, []string{models.RoleOwner, models.RoleAdmin, models.RoleTeacher}},
+		{action{name: "toggle test", update: press("toggle_test_1")}, []string{models.RoleOwner, models.RoleAdmin, models.RoleTeacher}},
+		{action{name: "publish results", update: press("publish_results_1")}, []string{models.RoleOwner, models.RoleAdmin, models.RoleTeacher}},
+		{action{name: "reset attempts", update: text(admin.ButtonResetAttempts)}, []string{models.RoleOwner, models.RoleAdmin, models.RoleTeacher}},
+		{action{name: "reset attempts answer", update: text("2 1"), state: "waiting_for_attempt_reset"}, []string{models.RoleOwner, models.RoleAdmin, models.RoleTeacher}},
+		{action{name: "certificate template", update: text(admin.ButtonCertificateTemplate)}, []string{models.RoleOwner, models.RoleAdmin}},
+		{action{name: "certificate template answer", update: text("SERTIFIKAT"), state: "waiting_for_certificate_template"}, []string{models.RoleOwner, models.RoleAdmin}},
+		{action{name: "broadcast", update: text(admin.ButtonBroadcast)}, []string{models.RoleOwner, models.RoleAdmin}},
+		{action{name: "broadcast answer", update: text("Salom"), state: "waiting_for_broadcast_message"}, []string{models.RoleOwner, models.RoleAdmin}},
+		{action{name: "delete channel", update: press("delete_channel_@news")}, []string{models.RoleOwner, models.RoleAdmin}},
+		{action{name: "users dump", update: text(admin.ButtonUsersDump)}, []string{models.RoleOwner, models.RoleAdmin}},
+		{action{name: "audit log", update: press("audit_page_2")}, []string{models.RoleOwner, models.RoleAdmin}},
+		{action{name: "add admin", update: text(admin.ButtonAddAdmin)}, []string{models.RoleOwner}},
+		{action{name: "add admin answer", update: text("8 admin"), state: "waiting_for_admin_id"}, []string{models.RoleOwner}},
+	}
+
+	for _, role := range append(models.Roles, none) {
+		for _, tt := range tests {
+			guard, server := newGuard(t)
+			guard.Commands = admin.Commands
+			guard.CallbackPrefixes = admin.CallbackPrefixes
+			guard.StatePermission = router.Permission
+
+			ctx := context.Background()
+			if role != none {
+				guard.Repos.Admins.Add(ctx, userID, role)
+			}
+			if tt.action.state != "" {
+				state.Set(ctx, userID, tt.action.state, nil, time.Hour)
+			}
+
+			want := false
+			for _, allowed := range tt.allowed {
+				want = want || allowed == role
+			}
+			if got := handled(guard, tt.action.update(server)); got != want {
+				t.Errorf("role %q, %s: allowed = %v, want %v", role, tt.action.name, got, want)
+			}
+		}
+	}
+}
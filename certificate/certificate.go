@@ -0,0 +1,228 @@
+package certificate
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"strings"
+	"tgbot/messenger"
+	"tgbot/models"
+	"tgbot/storage"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// TemplateSetting is the settings key holding the admin defined template.
+const TemplateSetting = "certificate_template"
+
+// DefaultTemplate is used until an admin sets a template. The first line is
+// printed as the heading and the line with {name} in large letters.
+const DefaultTemplate = `SERTIFIKAT
+Ushbu sertifikat
+{name}
+ga "{test}" testida {score} ball ({percent}%) to'plab,
+{rank}-o'rinni egallagani uchun berildi.
+Sana: {date}`
+
+// Placeholders lists the values available in templates.
+const Placeholders = "{name}, {test}, {score}, {max}, {percent}, {rank}, {date}, {code}"
+
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Button returns the keyboard offering a certificate for the test.
+func Button(testID int) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Sertifikat olish", fmt.Sprintf("certificate_%d", testID)),
+		),
+	)
+}
+
+// Final reports whether the ranks of the test no longer change, so that
+// certificates can be issued: once it is closed or past its closing time.
+// Published results are not enough, as a test can be published while it still
+// takes submissions. An issued certificate keeps its rank for good.
+func Final(test models.Test, now time.Time) bool {
+	if test.Status == models.TestStatusClosed {
+		return true
+	}
+	return !test.ClosesAt.IsZero() && !now.Before(test.ClosesAt)
+}
+
+// Render draws the certificate as a one page PDF.
+func Render(template string, c models.Certificate) []byte {
+	percent := 0.0
+	if c.MaxScore > 0 {
+		percent = c.Score / c.MaxScore * 100
+	}
+
+	replacer := strings.NewReplacer(
+		"{name}", c.FullName,
+		"{test}", c.TestTitle,
+		"{score}", fmt.Sprintf("%.2f", c.Score),
+		"{max}", fmt.Sprintf("%.2f", c.MaxScore),
+		"{percent}", fmt.Sprintf("%.1f", percent),
+		"{rank}", fmt.Sprint(c.Rank),
+		"{date}", c.CreatedAt.Local().Format("02.01.2006"),
+		"{code}", c.Code,
+	)
+
+	p := &page{}
+	p.rect(20, 20, pageWidth-40, pageHeight-40, 3)
+	p.rect(30, 30, pageWidth-60, pageHeight-60, 1)
+
+	y := pageHeight - 70
+	for i, line := range strings.Split(template, "\n") {
+		f, size := helvetica, 18.0
+		switch {
+		case i == 0:
+			f, size = helveticaBold, 40
+		case strings.Contains(line, "{name}"):
+			f, size = helveticaBold, 30
+		}
+
+		y -= size * 1.2
+		p.centeredText(f, size, y, replacer.Replace(strings.TrimSpace(line)))
+		y -= size * 0.5
+	}
+
+	p.centeredText(helvetica, 11, 50, fmt.Sprintf("Tekshirish kodi: %s  (botda /verify %s)", c.Code, c.Code))
+	return p.bytes()
+}
+
+// Send generates the certificate of the user for the test, or resends the
+// already issued one, as a PDF document.
+func Send(ctx context.Context, chatID int64, testID int, repos *storage.Repos, botInstance messenger.Messenger) {
+	test, err := repos.Tests.Get(ctx, testID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting test from database", "test_id", testID, "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Bunday test topilmadi.")
+		botInstance.Send(msg)
+		return
+	}
+
+	if !test.ResultsVisible() {
+		msg := tgbotapi.NewMessage(chatID, "Bu test natijalari hali e'lon qilinmagan.")
+		botInstance.Send(msg)
+		return
+	}
+	if !Final(test, time.Now()) {
+		msg := tgbotapi.NewMessage(chatID, "Sertifikat test yopilgandan keyin beriladi.")
+		botInstance.Send(msg)
+		return
+	}
+
+	user, err := repos.Users.Get(ctx, chatID)
+	if err != nil || user.FullName == "" {
+		msg := tgbotapi.NewMessage(chatID, "Sertifikat olish uchun avval ro'yxatdan o'ting: /start")
+		botInstance.Send(msg)
+		return
+	}
+
+	entries, err := repos.Submissions.Leaderboard(ctx, testID, models.RankFilter{}, 0, chatID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting rank for certificate", "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Sertifikat yaratishda xatolik yuz berdi.")
+		botInstance.Send(msg)
+		return
+	}
+	if len(entries) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "Siz bu testda qatnashmagansiz.")
+		botInstance.Send(msg)
+		return
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error generating certificate code", "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Sertifikat yaratishda xatolik yuz berdi.")
+		botInstance.Send(msg)
+		return
+	}
+
+	issued, err := repos.Certificates.Add(ctx, models.Certificate{
+		Code:      code,
+		UserID:    chatID,
+		TestID:    testID,
+		FullName:  user.FullName,
+		TestTitle: test.Title,
+		Score:     entries[0].Score,
+		MaxScore:  entries[0].MaxScore,
+		Rank:      entries[0].Rank,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving certificate", "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Sertifikat yaratishda xatolik yuz berdi.")
+		botInstance.Send(msg)
+		return
+	}
+
+	template, err := repos.Settings.Get(ctx, TemplateSetting)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			slog.ErrorContext(ctx, "Error getting certificate template", "err", err)
+		}
+		template = DefaultTemplate
+	}
+
+	document := tgbotapi.NewDocumentUpload(chatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("sertifikat_%s.pdf", issued.Code),
+		Bytes: Render(template, issued),
+	})
+	if _, err := botInstance.Send(document); err != nil {
+		slog.ErrorContext(ctx, "Error sending certificate", "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Sertifikatni yuborishda xatolik yuz berdi.")
+		botInstance.Send(msg)
+	}
+}
+
+// HandleVerifyCommand handles "/verify <code>".
+func HandleVerifyCommand(ctx context.Context, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) {
+	chatID := msg.Chat.ID
+	code := strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/verify")))
+
+	if code == "" {
+		msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, sertifikat kodini kiriting. \n\n Namuna: /verify ABCD-EFGH-JKLM")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	certificate, err := repos.Certificates.ByCode(ctx, code)
+	if err == storage.ErrNotFound {
+		msgResponse := tgbotapi.NewMessage(chatID, "Bunday kodli sertifikat topilmadi. Sertifikat haqiqiy emas.")
+		botInstance.Send(msgResponse)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error verifying certificate", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Sertifikatni tekshirishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	msgResponse := tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"Sertifikat haqiqiy.\n\nIshtirokchi: %s\nTest: %s\nBall: %.2f / %.2f\nO'rin: %d\nSana: %s",
+		certificate.FullName, certificate.TestTitle, certificate.Score, certificate.MaxScore,
+		certificate.Rank, certificate.CreatedAt.Local().Format("02.01.2006"),
+	))
+	botInstance.Send(msgResponse)
+}
+
+// generateCode returns a random code like "ABCD-EFGH-JKLM" without look-alike characters.
+func generateCode() (string, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for i, v := range random {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(codeAlphabet[int(v)%len(codeAlphabet)])
+	}
+	return b.String(), nil
+}
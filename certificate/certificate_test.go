@@ -0,0 +1,29 @@
+package certificate
+
+import (
+	"testing"
+	"time"
+
+	"tgbot/models"
+)
+
+func TestFinal(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		test models.Test
+		want bool
+	}{
+		{name: "open", test: models.Test{Status: models.TestStatusActive}, want: false},
+		{name: "open until later", test: models.Test{Status: models.TestStatusActive, ClosesAt: now.Add(time.Hour)}, want: false},
+		{name: "past closing time", test: models.Test{Status: models.TestStatusActive, ClosesAt: now.Add(-time.Minute)}, want: true},
+		{name: "closed", test: models.Test{Status: models.TestStatusClosed}, want: true},
+		{name: "published but open", test: models.Test{Status: models.TestStatusActive, ResultsPublished: true}, want: false},
+		{name: "published and closed", test: models.Test{Status: models.TestStatusClosed, ResultsPublished: true}, want: true},
+	}
+	for _, tt := range tests {
+		if got := Final(tt.test, now); got != tt.want {
+			t.Errorf("%s: Final = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
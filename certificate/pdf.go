@@ -0,0 +1,142 @@
+package certificate
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Page size of an A4 sheet in landscape orientation, in points.
+const (
+	pageWidth  = 842.0
+	pageHeight = 595.0
+)
+
+type font struct {
+	resource string
+	name     string
+	widths   [95]int // glyph widths of the ASCII characters 32..126 per 1000 units
+}
+
+var (
+	helvetica = font{resource: "F1", name: "Helvetica", widths: [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}}
+	helveticaBold = font{resource: "F2", name: "Helvetica-Bold", widths: [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}}
+)
+
+// textWidth returns the width of ASCII text in points.
+func (f font) textWidth(text string, size float64) float64 {
+	total := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c >= 32 && c <= 126 {
+			total += f.widths[c-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// page collects drawing operators of a single page.
+type page struct {
+	content bytes.Buffer
+}
+
+func (p *page) rect(x, y, w, h, lineWidth float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f %.2f %.2f re S\n", lineWidth, x, y, w, h)
+}
+
+// centeredText draws one line of text horizontally centered at height y.
+func (p *page) centeredText(f font, size, y float64, text string) {
+	text = toASCII(text)
+	x := (pageWidth - f.textWidth(text, size)) / 2
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", f.resource, size, x, y, escape(text))
+}
+
+// bytes renders the page as a complete PDF document using the standard
+// Helvetica fonts, so no font files need to be embedded.
+func (p *page) bytes() []byte {
+	var (
+		out     bytes.Buffer
+		offsets []int
+	)
+
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Contents 4 0 R /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> >>", pageWidth, pageHeight))
+	object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", helvetica.name))
+	object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", helveticaBold.name))
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
+
+func escape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`).Replace(text)
+}
+
+// cyrillic transliterates Uzbek and Russian Cyrillic into Uzbek Latin, since the
+// standard PDF fonts cannot draw Cyrillic glyphs.
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "j",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "x", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "sh", 'ъ': "'", 'ы': "i", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'ў': "o'", 'қ': "q", 'ғ': "g'", 'ҳ': "h",
+}
+
+// toASCII replaces characters the standard fonts cannot draw.
+func toASCII(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r < 128:
+			b.WriteRune(r)
+		case r == 'ʻ' || r == 'ʼ' || r == '‘' || r == '’' || r == '`':
+			b.WriteByte('\'')
+		case r == '“' || r == '”':
+			b.WriteByte('"')
+		case r == '—' || r == '–':
+			b.WriteByte('-')
+		default:
+			lower := []rune(strings.ToLower(string(r)))[0]
+			latin, ok := cyrillic[lower]
+			if !ok {
+				b.WriteByte('?')
+				continue
+			}
+			if lower != r && latin != "" {
+				latin = strings.ToUpper(latin[:1]) + latin[1:]
+			}
+			b.WriteString(latin)
+		}
+	}
+	return b.String()
+}
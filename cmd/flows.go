@@ -0,0 +1,121 @@
+package main
+
+import (
+	"tgbot/admin"
+	"tgbot/fsm"
+	"tgbot/models"
+	"tgbot/register"
+	"time"
+)
+
+// conversationTTL is how long test uploads and answers wait for the user, who
+// may well come back to them later in the day.
+const conversationTTL = 24 * time.Hour
+
+// router handles messages of chats in the middle of a conversation.
+var router *fsm.Router
+
+func newRouter() *fsm.Router {
+	return fsm.NewRouter(flows()...)
+}
+
+// flows are every conversation the bot has.
+func flows() []fsm.Flow {
+	return []fsm.Flow{
+		register.Flow,
+		testUploadFlow(),
+		testingFlow(),
+		admin.BroadcastFlow,
+		admin.ChannelFlow,
+		admin.AdminsFlow,
+		admin.AttemptResetFlow,
+		admin.CertificateTemplateFlow,
+	}
+}
+
+// testUploadFlow creates a test: its details, file and answer key. The test
+// stays a draft until the key is saved.
+func testUploadFlow() fsm.Flow {
+	return fsm.Flow{
+		Name:       "test_upload",
+		Permission: models.PermissionUploadTests,
+		States: []fsm.State{
+			{
+				Name:     "waiting_for_test_title",
+				TTL:      conversationTTL,
+				Prompt:   fsm.Ask("Iltimos, test nomini kiriting:"),
+				Validate: validateTestTitle,
+				Handle:   handleTestTitle,
+			},
+			{
+				Name:   "waiting_for_test_subject",
+				TTL:    conversationTTL,
+				Prompt: fsm.Ask("Iltimos, test fanini kiriting: \n\n Namuna: Matematika"),
+				Handle: handleTestSubject,
+			},
+			{
+				Name:     "waiting_for_test_attempts",
+				TTL:      conversationTTL,
+				Prompt:   fsm.Ask("Har bir o'quvchi nechta urinish qila oladi? \n\n 1 - bir marta, 0 - cheksiz (mashq rejimi)"),
+				Validate: validateTestAttempts,
+				Handle:   handleTestAttempts,
+			},
+			{
+				Name:     "waiting_for_test_visibility",
+				TTL:      conversationTTL,
+				Prompt:   askTestVisibility,
+				Validate: validateTestVisibility,
+				Handle:   handleTestVisibility,
+			},
+			{
+				Name:     "waiting_for_test_schedule",
+				TTL:      conversationTTL,
+				Prompt:   askTestSchedule,
+				Validate: validateTestSchedule,
+				Handle:   handleTestSchedule,
+			},
+			{
+				Name:     "waiting_for_test_file",
+				TTL:      conversationTTL,
+				Prompt:   fsm.Ask("Iltimos, test faylini yuklang:"),
+				Validate: validateDocument,
+				Handle:   handleDocument,
+			},
+			{
+				Name:     "waiting_for_test_answers",
+				TTL:      conversationTTL,
+				Prompt:   fsm.Ask("Fayl muvaffaqiyatli saqlandi. Iltimos, endi javoblarni yuboring. \n\n Savollar balli va noto'g'ri javob uchun jarima ixtiyoriy: \n abcd... \n #ball 1-10=1.1; 11-20=2.1 \n #jarima 0.25 \n\n Turli xil savollar uchun har bir qatorda bitta javob: \n 1) a \n 2) [a,c] \n 3) =3.14~0.01 \n 4) \"paris|parij\""),
+				Validate: validateTestAnswers,
+				Handle:   handleTestAnswers,
+			},
+		},
+		Cancel: admin.ReturnToMenu("Test yaratish bekor qilindi. Test qoralama holatida qoldi."),
+	}
+}
+
+// testingFlow collects a user's answers to a test and asks to confirm them
+// before they are graded by handleConfirmAnswers.
+func testingFlow() fsm.Flow {
+	return fsm.Flow{
+		Name: "testing",
+		States: []fsm.State{
+			{
+				Name:     "waiting_for_answers",
+				TTL:      conversationTTL,
+				Prompt:   fsm.Ask("Iltimos, javoblaringizni yuboring. \n\n Namuna: abccd yoki 1a 2b 3c 4c 5d \n\n Bir nechta variantli, sonli va matnli javoblar uchun: 1a 2ac 3=3.14 4-paris"),
+				Validate: validateAnswers,
+				Handle:   handleAnswers,
+			},
+			{
+				// New answers sent instead of confirming replace the old ones
+				Name:     "waiting_for_answers_confirmation",
+				TTL:      conversationTTL,
+				Prompt:   askAnswersConfirmation,
+				Validate: validateAnswers,
+				Handle:   handleAnswers,
+				Back:     "waiting_for_answers",
+			},
+		},
+		Cancel: fsm.Ask("Javob yuborish bekor qilindi."),
+	}
+}
@@ -2,171 +2,338 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"tgbot/admin"
+	"tgbot/answers"
+	"tgbot/audit"
+	"tgbot/auth"
+	"tgbot/certificate"
+	"tgbot/config"
+	"tgbot/dispatcher"
+	"tgbot/fsm"
+	"tgbot/logging"
+	"tgbot/messenger"
+	"tgbot/metrics"
+	"tgbot/migration"
+	"tgbot/models"
 	"tgbot/register"
+	"tgbot/results"
+	"tgbot/scheduler"
+	"tgbot/state"
 	"tgbot/storage"
-	"tgbot/stats"
+	"tgbot/webhook"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
 func main() {
-	connStr := "user=godb password=0208 dbname=testbot sslmode=disable"
-	db, err := storage.OpenDatabase(connStr)
+	configPath := flag.String("config", "", "path to the YAML config file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-config file] [migrate [up | down [steps] | status]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	if flag.Arg(0) == "migrate" {
+		db, err := config.InitializeDatabase(cfg.DB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = runMigrate(db, flag.Args()[1:])
+		db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := logging.Setup(cfg.LogLevel); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := config.Setup(cfg.DB, cfg.BotToken, cfg.Timeouts.Telegram); err != nil {
+		logging.Fatal("Error starting bot", "err", err)
+	}
+	db := config.GetDB()
 	defer db.Close()
+	repos := storage.NewPostgresRepos(db, cfg.Timeouts.Query)
+	botInstance := config.GetBot()
 
-	botToken := "5111237025:AAHhUYhFG4xuu6hVjhka8YuBYNBVnrtzGps"
-	botInstance, err := tgbotapi.NewBotAPI(botToken)
+	applied, err := migration.Up(db)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Error migrating database", "err", err)
+	}
+	for _, m := range applied {
+		slog.Info("Applied migration", "version", m.Version, "name", m.Name)
 	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	for _, adminID := range cfg.Admins {
+		if err := repos.Admins.Seed(ctx, adminID, models.RoleAdmin); err != nil {
+			logging.Fatal("Error adding admin from config", "admin_id", adminID, "err", err)
+		}
+	}
+	for _, ownerID := range cfg.Owners {
+		if err := repos.Admins.Add(ctx, ownerID, models.RoleOwner); err != nil {
+			logging.Fatal("Error adding owner from config", "owner_id", ownerID, "err", err)
+		}
+	}
+	if len(cfg.Owners) == 0 {
+		slog.Info("No owners configured; admins can only be managed in the database")
+	}
+
+	router = newRouter()
+
+	if cfg.StateStore == config.StateStorePostgres {
+		state.Use(state.NewPostgresStore(storage.NewDB(db, cfg.Timeouts.Query)))
+	}
+
+	bot := messenger.NewTelegram(botInstance, cfg.Timeouts.Download)
+
+	if cfg.MetricsListen != "" {
+		metrics.NewGaugeFunc("tgbot_conversation_states", "Chats in a multi-step conversation, by state.", "state", state.Counts)
+		go func() {
+			slog.InfoContext(ctx, "Serving metrics", "address", cfg.MetricsListen+metrics.Path)
+			if err := metrics.Serve(ctx, cfg.MetricsListen); err != nil && err != http.ErrServerClosed {
+				slog.ErrorContext(ctx, "Metrics server error", "err", err)
+			}
+		}()
+	}
+
+	go scheduler.Run(ctx, repos, bot)
+
+	guard := &auth.Guard{
+		Repos:            repos,
+		Bot:              bot,
+		Commands:         admin.Commands,
+		CallbackPrefixes: admin.CallbackPrefixes,
+		StatePermission:  router.Permission,
+	}
+	handle := guard.Wrap(func(ctx context.Context, update tgbotapi.Update) {
+		handleUpdate(ctx, update, repos, bot)
+	})
+
+	// Queued updates are still handled after a stop signal, so work has its
+	// own context, cancelled once the shutdown timeout is over.
+	work, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+	pool := dispatcher.New(work, cfg.Workers, cfg.QueueSize, func(ctx context.Context, update tgbotapi.Update) {
+		ctx, cancel := context.WithTimeout(ctx, cfg.Timeouts.Update)
+		defer cancel()
+		ctx = logging.With(ctx, "update_id", update.UpdateID, "chat_id", dispatcher.ChatID(update))
+		metrics.Updates.Inc(dispatcher.Type(update))
+
+		start := time.Now()
+		handle(ctx, update)
+		slog.DebugContext(ctx, "Update handled", "duration", time.Since(start))
+	})
+
+	if cfg.Mode == config.ModeWebhook {
+		runWebhook(ctx, cfg, pool, botInstance)
+	} else {
+		runPolling(ctx, pool, botInstance)
+	}
+
+	slog.Info("Waiting for queued updates to finish...")
+	timer := time.AfterFunc(cfg.Timeouts.Shutdown, func() {
+		slog.Info("Shutdown timeout reached, cancelling updates in progress")
+		stopWork()
+	})
+	pool.Close()
+	timer.Stop()
+}
+
+func runPolling(ctx context.Context, pool *dispatcher.Pool, botInstance *tgbotapi.BotAPI) {
+	// Telegram does not deliver updates through getUpdates while a webhook is set
+	if _, err := botInstance.RemoveWebhook(); err != nil {
+		slog.ErrorContext(ctx, "Error removing webhook", "err", err)
+	}
+
 	offset := 0
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Shutting down bot...")
+			slog.InfoContext(ctx, "Shutting down bot...")
 			return
 		default:
 			updates, err := botInstance.GetUpdates(tgbotapi.NewUpdate(offset))
 			if err != nil {
-				log.Printf("Error getting updates: %v", err)
+				slog.ErrorContext(ctx, "Error getting updates", "err", err)
 				time.Sleep(5 * time.Second)
 				continue
 			}
 			for _, update := range updates {
-				handleUpdate(update, db, botInstance)
+				// Updates not queued before shutdown are left unconfirmed, so
+				// Telegram delivers them again after the restart.
+				if err := pool.Submit(ctx, update); err != nil {
+					break
+				}
 				offset = update.UpdateID + 1
 			}
 		}
 	}
 }
 
-func handleUpdate(update tgbotapi.Update, db *sql.DB, botInstance *tgbotapi.BotAPI) {
+func runWebhook(ctx context.Context, cfg config.Config, pool *dispatcher.Pool, botInstance *tgbotapi.BotAPI) {
+	if err := webhook.Register(botInstance, cfg.Webhook.URL, cfg.Webhook.SecretToken); err != nil {
+		logging.Fatal("Error registering webhook", "err", err)
+	}
+
+	handler := webhook.NewHandler(cfg.Webhook.SecretToken, pool.Submit)
+
+	slog.InfoContext(ctx, "Listening for webhook updates", "address", cfg.Webhook.Listen+cfg.WebhookPath())
+	err := webhook.Serve(ctx, cfg.Webhook.Listen, cfg.WebhookPath(), cfg.Webhook.CertFile, cfg.Webhook.KeyFile, handler)
+	if err != nil && err != http.ErrServerClosed {
+		logging.Fatal("Webhook server error", "err", err)
+	}
+	slog.InfoContext(ctx, "Shutting down bot...")
+}
+
+func handleUpdate(ctx context.Context, update tgbotapi.Update, repos *storage.Repos, botInstance messenger.Messenger) {
 	if update.Message != nil {
-		handleMessage(update.Message, db, botInstance)
+		handleMessage(ctx, update.Message, repos, botInstance)
 	} else if update.CallbackQuery != nil {
-		handleCallbackQuery(update.CallbackQuery, db, botInstance)
+		handleCallbackQuery(ctx, update.CallbackQuery, repos, botInstance)
 	} else {
-		log.Printf("Unsupported update type: %T", update)
+		slog.WarnContext(ctx, "Unsupported update type", "update_type", fmt.Sprintf("%T", update))
 	}
 }
 
-func handleMessage(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
+func handleMessage(ctx context.Context, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) {
 	chatID := msg.Chat.ID
 	text := msg.Text
 
-	log.Printf("Received message: %s", text)
+	handler := handlerName(text)
+	defer observeHandler(&handler, time.Now())
+	ctx = logging.With(ctx, "handler", handler)
+	slog.DebugContext(ctx, "Received message")
 
-	if state, exists := stats.UsStats[chatID]; exists {
-		switch state {
-		case "waiting_for_broadcast_message":
-			admin.HandleBroadcastMessage(msg, db, botInstance)
-			delete(stats.UsStats, chatID)
-			return
-		case "waiting_for_channel_link":
-			admin.HandleChannelLink(msg, db, botInstance)
-			delete(stats.UsStats, chatID)
-			return
-		case "waiting_for_answers":
-			handleAnswers(msg, db, botInstance)
-			delete(stats.UsStats, chatID)
-			return
-		case "waiting_for_test_file":
-			handleDocument(msg, db, botInstance)
-			delete(stats.UsStats, chatID)
-			return
-		case "waiting_for_test_answers":
-			handleTestAnswers(msg, db, botInstance)
-			delete(stats.UsStats, chatID)
-			return
-		case "waiting_for_admin_id":
-			admin.HandleAdminAdd(msg, db, botInstance)
-			delete(stats.UsStats, chatID)
-			return
-		case "waiting_for_admin_id_remove":
-			admin.HandleAdminRemove(msg, db, botInstance)
-			delete(stats.UsStats, chatID)
-			return
-		case "waiting_for_full_name":
-			register.HandleFullName(msg, db, botInstance)
-			return
-		case "waiting_for_region":
-			register.HandleRegion(msg, db, botInstance)
-			return
-		case "waiting_for_district":
-			register.HandleDistrict(msg, db, botInstance)
-			return
-		case "waiting_for_school":
-			register.HandleSchool(msg, db, botInstance)
-			return
-		case "waiting_for_grade":
-			register.HandleGrade(msg, db, botInstance)
-			return
-		case "waiting_for_phone":
-			register.HandlePhone(msg, db, botInstance)
-			return
-		}
+	c := fsm.NewContext(ctx, chatID, msg, repos, botInstance)
+	if router.Dispatch(c) {
+		handler = c.Flow
+		return
 	}
 
 	if text == "/start" {
-		handleStartCommand(msg, db, botInstance)
-		storage.AddUserToDatabase(db, int(msg.Chat.ID))
+		handleStartCommand(ctx, msg, repos, botInstance)
+		repos.Users.Add(ctx, msg.Chat.ID)
 	} else if text == "/admin" {
-		admin.HandleAdminCommand(msg, db, botInstance)
+		admin.HandleAdminCommand(ctx, msg, repos, botInstance)
+	} else if text == "/top" || strings.HasPrefix(text, "/top ") {
+		results.HandleTopCommand(ctx, msg, repos, botInstance)
+	} else if text == "/verify" || strings.HasPrefix(text, "/verify ") {
+		certificate.HandleVerifyCommand(ctx, msg, repos, botInstance)
+	} else if text == fsm.CancelCommand || text == fsm.BackCommand {
+		msgResponse := tgbotapi.NewMessage(chatID, "Hozir bekor qilinadigan amal yo'q.")
+		botInstance.Send(msgResponse)
 	} else {
-		handleDefaultMessage(msg, db, botInstance)
+		handleDefaultMessage(ctx, msg, repos, botInstance)
+	}
+}
+
+// commands are the commands the bot answers to outside of flows.
+var commands = map[string]bool{
+	"/start": true, "/admin": true, "/top": true, "/verify": true,
+	fsm.CancelCommand: true, fsm.BackCommand: true,
+}
+
+// handlerName names the handler of a message in logs and metrics. Only known
+// commands and admin buttons are named by their text: any other text may be
+// personal data, and would give metrics a label value per message.
+func handlerName(text string) string {
+	if strings.HasPrefix(text, "/") {
+		name, _, _ := strings.Cut(text, " ")
+		if commands[name] {
+			return name
+		}
+		return "other"
+	}
+	if _, ok := admin.Commands[text]; ok {
+		return text
 	}
+	return "message"
+}
+
+// callbackActions are the data of inline buttons without an argument, and
+// callbackPrefixes those of buttons carrying a test ID, page or channel.
+var (
+	callbackActions  = []string{"check_subscription", "start_test", "top_all", "confirm_answers", "retry_answers", "cancel_delete_channel", "audit_export"}
+	callbackPrefixes = []string{"start_test_", "check_answers_", "top_", "certificate_", "toggle_test_", "publish_results_", "delete_channel_", "confirm_delete_channel_", "audit_page_"}
+)
+
+// callbackName names the handler of a callback in metrics by the button
+// pressed, leaving out its argument so there are few names.
+func callbackName(data string) string {
+	for _, action := range callbackActions {
+		if data == action {
+			return "callback " + action
+		}
+	}
+	for _, prefix := range callbackPrefixes {
+		if strings.HasPrefix(data, prefix) {
+			return "callback " + strings.TrimSuffix(prefix, "_")
+		}
+	}
+	return "callback unknown"
+}
+
+// observeHandler records the time since start for the handler, which is read
+// only when the handler returns.
+func observeHandler(handler *string, start time.Time) {
+	metrics.HandlerDuration.Observe(time.Since(start).Seconds(), *handler)
 }
 
-func handleStartCommand(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
+func handleStartCommand(ctx context.Context, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) {
 	chatID := msg.Chat.ID
 	userID := msg.From.ID
 
-	log.Printf("Adding user to database: %d ", userID)
-	err := storage.AddUserToDatabase(db, userID)
+	slog.DebugContext(ctx, "Adding user to database", "user_id", userID)
+	err := repos.Users.Add(ctx, int64(userID))
 	if err != nil {
-		log.Printf("Error adding user to database: %v", err)
+		slog.ErrorContext(ctx, "Error adding user to database", "err", err)
 		return
 	}
 
-	channels, err := storage.GetChannelsFromDatabase(db)
+	channels, err := repos.Channels.All(ctx)
 	if err != nil {
-		log.Printf("Error getting channels from database: %v", err)
+		slog.ErrorContext(ctx, "Error getting channels from database", "err", err)
 		return
 	}
 
-	log.Printf("Checking subscription for user %d", chatID)
-	if isUserSubscribedToChannels(chatID, channels, botInstance) {
+	slog.DebugContext(ctx, "Checking subscription for user", "chat_id", chatID)
+	if isUserSubscribedToChannels(ctx, chatID, channels, botInstance) {
 
-		user, err := storage.GetUserFromDatabase(db, chatID)
+		user, err := repos.Users.Get(ctx, chatID)
 		if err != nil {
-			log.Printf("Error getting user from database: %v", err)
+			slog.ErrorContext(ctx, "Error getting user from database", "err", err)
 			return
 		}
 
-		fmt.Println("Full:", user.FullName)
-
-		if user.FullName == "" {
-			stats.UsStats[chatID] = "waiting_for_full_name"
-			msg := tgbotapi.NewMessage(chatID, "Iltimos, ism va familyangizni kiriting: \n\n Namuna: Baxtiyor Urolov")
-			botInstance.Send(msg)
+		if next := register.Resume(user); next != fsm.End {
+			router.Start(fsm.NewContext(ctx, chatID, msg, repos, botInstance), next)
 			return
 		}
 
@@ -178,7 +345,7 @@ func handleStartCommand(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi
 		msg.ReplyMarkup = inlineKeyboard
 		botInstance.Send(msg)
 	} else {
-		log.Printf("User %d is not subscribed to required channels", chatID)
+		slog.InfoContext(ctx, "User is not subscribed to required channels", "chat_id", chatID)
 		inlineKeyboard := createSubscriptionKeyboard(channels)
 		msg := tgbotapi.NewMessage(chatID, "Iltimos, avval kanallarga azo bo'ling:")
 		msg.ReplyMarkup = inlineKeyboard
@@ -186,35 +353,33 @@ func handleStartCommand(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi
 	}
 }
 
-func handleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery, db *sql.DB, botInstance *tgbotapi.BotAPI) {
+func handleCallbackQuery(ctx context.Context, callbackQuery *tgbotapi.CallbackQuery, repos *storage.Repos, botInstance messenger.Messenger) {
 	chatID := callbackQuery.Message.Chat.ID
 	messageID := callbackQuery.Message.MessageID
+	ctx = logging.With(ctx, "handler", "callback "+callbackQuery.Data)
+	handler := callbackName(callbackQuery.Data)
+	defer observeHandler(&handler, time.Now())
 
-	channels, err := storage.GetChannelsFromDatabase(db)
+	channels, err := repos.Channels.All(ctx)
 	if err != nil {
-		log.Printf("Error getting channels from database: %v", err)
+		slog.ErrorContext(ctx, "Error getting channels from database", "err", err)
 		return
 	}
 
 	if callbackQuery.Data == "check_subscription" {
-		if isUserSubscribedToChannels(chatID, channels, botInstance) {
-
-			deleteMsg := tgbotapi.NewDeleteMessage(chatID, messageID)
-			botInstance.Send(deleteMsg)
-			user, err := storage.GetUserFromDatabase(db, chatID)
-		if err != nil {
-			log.Printf("Error getting user from database: %v", err)
-			return
-		}
+		if isUserSubscribedToChannels(ctx, chatID, channels, botInstance) {
 
-		fmt.Println("Full:", user.FullName)
+			botInstance.Delete(chatID, messageID)
+			user, err := repos.Users.Get(ctx, chatID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error getting user from database", "err", err)
+				return
+			}
 
-		if user.FullName == "" {
-			stats.UsStats[chatID] = "waiting_for_full_name"
-			msg := tgbotapi.NewMessage(chatID, "Iltimos, ism va familyangizni kiriting: \n\n Namuna: Baxtiyor Urolov")
-			botInstance.Send(msg)
-			return
-		}
+			if next := register.Resume(user); next != fsm.End {
+				router.Start(fsm.NewContext(ctx, chatID, nil, repos, botInstance), next)
+				return
+			}
 			msg := tgbotapi.NewMessage(chatID, "Assalomu alaykum, siz kanallarga azo bo'ldingiz!")
 			startTestButton := tgbotapi.NewInlineKeyboardButtonData("Testni boshlash", "start_test")
 			inlineKeyboard := tgbotapi.NewInlineKeyboardMarkup(
@@ -229,36 +394,128 @@ func handleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery, db *sql.DB, botI
 			botInstance.Send(msg)
 		}
 	} else if callbackQuery.Data == "start_test" {
-		handleStartTest(chatID, messageID, db, botInstance)
-	} else if callbackQuery.Data == "check_answers" {
-		handleCheckAnswers(chatID, messageID, botInstance)
+		handleTestList(ctx, chatID, messageID, repos, botInstance)
+	} else if strings.HasPrefix(callbackQuery.Data, "start_test_") {
+		testID, err := strconv.Atoi(strings.TrimPrefix(callbackQuery.Data, "start_test_"))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error parsing test ID", "err", err)
+			return
+		}
+		handleStartTest(ctx, chatID, messageID, testID, repos, botInstance)
+	} else if strings.HasPrefix(callbackQuery.Data, "check_answers_") {
+		testID, err := strconv.Atoi(strings.TrimPrefix(callbackQuery.Data, "check_answers_"))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error parsing test ID", "err", err)
+			return
+		}
+		handleCheckAnswers(ctx, chatID, messageID, testID, repos, botInstance)
+	} else if callbackQuery.Data == "top_all" {
+		results.ShowOverallLeaderboard(ctx, chatID, models.RankFilter{}, results.DefaultTopSize, repos, botInstance)
+	} else if strings.HasPrefix(callbackQuery.Data, "top_") {
+		testID, err := strconv.Atoi(strings.TrimPrefix(callbackQuery.Data, "top_"))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error parsing test ID", "err", err)
+			return
+		}
+		results.ShowTestLeaderboard(ctx, chatID, testID, models.RankFilter{}, results.DefaultTopSize, repos, botInstance)
+	} else if strings.HasPrefix(callbackQuery.Data, "certificate_") {
+		testID, err := strconv.Atoi(strings.TrimPrefix(callbackQuery.Data, "certificate_"))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error parsing test ID", "err", err)
+			return
+		}
+		certificate.Send(ctx, chatID, testID, repos, botInstance)
+	} else if callbackQuery.Data == "confirm_answers" {
+		handleConfirmAnswers(ctx, chatID, messageID, repos, botInstance)
+	} else if callbackQuery.Data == "retry_answers" {
+		handleRetryAnswers(ctx, chatID, messageID, repos, botInstance)
+	} else if strings.HasPrefix(callbackQuery.Data, "toggle_test_") {
+		testID, err := strconv.Atoi(strings.TrimPrefix(callbackQuery.Data, "toggle_test_"))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error parsing test ID", "err", err)
+			return
+		}
+		admin.ToggleTestStatus(ctx, chatID, messageID, testID, repos, botInstance)
+	} else if strings.HasPrefix(callbackQuery.Data, "publish_results_") {
+		testID, err := strconv.Atoi(strings.TrimPrefix(callbackQuery.Data, "publish_results_"))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error parsing test ID", "err", err)
+			return
+		}
+		admin.PublishTestResults(ctx, chatID, messageID, testID, repos, botInstance)
 	} else if strings.HasPrefix(callbackQuery.Data, "delete_channel_") {
 		channel := strings.TrimPrefix(callbackQuery.Data, "delete_channel_")
 		admin.AskForChannelDeletionConfirmation(chatID, messageID, channel, botInstance)
 	} else if strings.HasPrefix(callbackQuery.Data, "confirm_delete_channel_") {
 		channel := strings.TrimPrefix(callbackQuery.Data, "confirm_delete_channel_")
-		admin.DeleteChannel(chatID, messageID, channel, db, botInstance)
+		admin.DeleteChannel(ctx, chatID, messageID, channel, repos, botInstance)
 	} else if callbackQuery.Data == "cancel_delete_channel" {
 		admin.CancelChannelDeletion(chatID, messageID, botInstance)
+	} else if strings.HasPrefix(callbackQuery.Data, "audit_page_") {
+		page, err := strconv.Atoi(strings.TrimPrefix(callbackQuery.Data, "audit_page_"))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error parsing audit page", "err", err)
+			return
+		}
+		admin.ShowAuditPage(ctx, chatID, messageID, page, repos, botInstance)
+	} else if callbackQuery.Data == "audit_export" {
+		admin.ExportAuditLog(ctx, chatID, repos, botInstance)
 	}
 }
 
-func handleStartTest(chatID int64, messageID int, db *sql.DB, botInstance *tgbotapi.BotAPI) {
+func handleTestList(ctx context.Context, chatID int64, messageID int, repos *storage.Repos, botInstance messenger.Messenger) {
 	// Delete the previous message
-	deleteMsg := tgbotapi.NewDeleteMessage(chatID, messageID)
-	botInstance.Send(deleteMsg)
+	botInstance.Delete(chatID, messageID)
 
-	fileID, fileName, err := storage.GetFileFromDatabase(db)
+	tests, err := repos.Tests.Active(ctx)
 	if err != nil {
-		log.Printf("Error getting file from database: %v", err)
+		slog.ErrorContext(ctx, "Error getting tests from database", "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Testlarni olishda xatolik yuz berdi.")
+		botInstance.Send(msg)
+		return
+	}
+
+	if len(tests) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "Hozircha faol testlar yo'q.")
+		botInstance.Send(msg)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, test := range tests {
+		label := test.Title
+		if test.Subject != "" {
+			label = fmt.Sprintf("%s (%s)", test.Title, test.Subject)
+		}
+		button := tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("start_test_%d", test.ID))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Qaysi testni boshlamoqchisiz?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	botInstance.Send(msg)
+}
+
+func handleStartTest(ctx context.Context, chatID int64, messageID int, testID int, repos *storage.Repos, botInstance messenger.Messenger) {
+	// Delete the previous message
+	botInstance.Delete(chatID, messageID)
+
+	test, ok := getActiveTest(ctx, chatID, testID, repos, botInstance)
+	if !ok {
+		return
+	}
+
+	fileID, fileName, err := repos.Files.Get(ctx, testID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting file from database", "err", err)
 		msg := tgbotapi.NewMessage(chatID, "Faylni olishda xatolik yuz berdi.")
 		botInstance.Send(msg)
 		return
 	}
 
-	fileBytes, err := downloadFile(botInstance, fileID)
+	fileBytes, err := botInstance.Download(ctx, fileID)
 	if err != nil {
-		log.Printf("Error downloading file: %v", err)
+		slog.ErrorContext(ctx, "Error downloading file", "err", err)
 		msg := tgbotapi.NewMessage(chatID, "Faylni olishda xatolik yuz berdi.")
 		botInstance.Send(msg)
 		return
@@ -269,14 +526,26 @@ func handleStartTest(chatID int64, messageID int, db *sql.DB, botInstance *tgbot
 		Bytes: fileBytes,
 	})
 	if _, err := botInstance.Send(document); err != nil {
-		log.Printf("Error sending document: %v", err)
+		slog.ErrorContext(ctx, "Error sending document", "err", err)
 		msg := tgbotapi.NewMessage(chatID, "Faylni yuborishda xatolik yuz berdi.")
 		botInstance.Send(msg)
 		return
 	}
 
-	msg := tgbotapi.NewMessage(chatID, "Test faylini oling. Javoblaringizni tekshirish uchun quyidagi tugmani bosing.")
-	checkAnswersButton := tgbotapi.NewInlineKeyboardButtonData("Javoblarni tekshirish", "check_answers")
+	session, err := repos.Sessions.Start(ctx, chatID, testID, sessionDeadline(test, time.Now()))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error starting test session", "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Testni boshlashda xatolik yuz berdi.")
+		botInstance.Send(msg)
+		return
+	}
+
+	text := "Test faylini oling. Javoblaringizni tekshirish uchun quyidagi tugmani bosing."
+	if !session.Deadline.IsZero() {
+		text += fmt.Sprintf("\n\nJavoblarni %s gacha yuborishingiz kerak.", session.Deadline.Local().Format(scheduleLayout))
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	checkAnswersButton := tgbotapi.NewInlineKeyboardButtonData("Javoblarni tekshirish", fmt.Sprintf("check_answers_%d", testID))
 	inlineKeyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(checkAnswersButton),
 	)
@@ -284,200 +553,520 @@ func handleStartTest(chatID int64, messageID int, db *sql.DB, botInstance *tgbot
 	botInstance.Send(msg)
 }
 
-func handleCheckAnswers(chatID int64, messageID int, botInstance *tgbotapi.BotAPI) {
+func handleCheckAnswers(ctx context.Context, chatID int64, messageID int, testID int, repos *storage.Repos, botInstance messenger.Messenger) {
 	// Delete the previous message
-	deleteMsg := tgbotapi.NewDeleteMessage(chatID, messageID)
-	botInstance.Send(deleteMsg)
+	botInstance.Delete(chatID, messageID)
 
-	stats.UsStats[chatID] = "waiting_for_answers"
-	msg := tgbotapi.NewMessage(chatID, "Iltimos, javoblaringizni quyidagi ketma-ketlikda yuboring. \n\n Namuna: abccd")
-	botInstance.Send(msg)
+	test, ok := getActiveTest(ctx, chatID, testID, repos, botInstance)
+	if !ok || !isWithinSession(ctx, chatID, test, repos, botInstance) || !hasAttemptsLeft(ctx, chatID, test, repos, botInstance) {
+		return
+	}
+
+	c := fsm.NewContext(ctx, chatID, nil, repos, botInstance)
+	c.Data[dataTestID] = strconv.Itoa(testID)
+	router.Start(c, "waiting_for_answers")
 }
 
-func handleDefaultMessage(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
+func handleDefaultMessage(ctx context.Context, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) {
 	chatID := msg.Chat.ID
 	text := msg.Text
 
 	switch text {
-	case "Kanal qo'shish":
-		stats.UsStats[chatID] = "waiting_for_channel_link"
-		msgResponse := tgbotapi.NewMessage(chatID, "Kanal linkini yuboring (masalan, https://t.me/your_channel):")
-		botInstance.Send(msgResponse)
-	case "Test faylini yuklash":
-		stats.UsStats[chatID] = "waiting_for_test_file"
-		msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, test faylini yuklang:")
-		botInstance.Send(msgResponse)
-	case "Test javoblarini yuklash":
-		stats.UsStats[chatID] = "waiting_for_test_answers"
-		msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, test javoblarini yuboring:")
-		botInstance.Send(msgResponse)
-	case "Admin qo'shish":
-		stats.UsStats[chatID] = "waiting_for_admin_id"
-		msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, yangi admin ID sini yuboring:")
-		botInstance.Send(msgResponse)
-	case "Admin o'chirish":
-		stats.UsStats[chatID] = "waiting_for_admin_id_remove"
-		msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, admin ID sini o'chirish uchun yuboring:")
-		botInstance.Send(msgResponse)
-	case "Kanal o'chirish":
-		admin.DisplayChannelsForDeletion(chatID, db, botInstance)
-	case "Statistika":
-		admin.HandleStatistics(msg, db, botInstance)
-	case "Habar yuborish":
-		stats.UsStats[chatID] = "waiting_for_broadcast_message"
-		msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, yubormoqchi bo'lgan habaringizni kiriting (Bekor qilish uchun /cancel):")
-		botInstance.Send(msgResponse)
-	case "DB olish":
-		admin.HandleDBDump(msg, db, botInstance)
-	case "Users olish":
-		admin.HandleUsersDump(msg, db, botInstance)
+	case admin.ButtonAddChannel:
+		router.Start(fsm.NewContext(ctx, chatID, msg, repos, botInstance), "waiting_for_channel_link")
+	case admin.ButtonCreateTest:
+		router.Start(fsm.NewContext(ctx, chatID, msg, repos, botInstance), "waiting_for_test_title")
+	case admin.ButtonTests:
+		admin.DisplayTests(ctx, chatID, repos, botInstance)
+	case admin.ButtonAddAdmin:
+		router.Start(fsm.NewContext(ctx, chatID, msg, repos, botInstance), "waiting_for_admin_id")
+	case admin.ButtonRemoveAdmin:
+		router.Start(fsm.NewContext(ctx, chatID, msg, repos, botInstance), "waiting_for_admin_id_remove")
+	case admin.ButtonResetAttempts:
+		router.Start(fsm.NewContext(ctx, chatID, msg, repos, botInstance), "waiting_for_attempt_reset")
+	case admin.ButtonRemoveChannel:
+		admin.DisplayChannelsForDeletion(ctx, chatID, repos, botInstance)
+	case admin.ButtonLeaderboard:
+		results.DisplayLeaderboardTests(ctx, chatID, repos, botInstance)
+	case admin.ButtonCertificateTemplate:
+		router.Start(fsm.NewContext(ctx, chatID, msg, repos, botInstance), "waiting_for_certificate_template")
+	case admin.ButtonStatistics:
+		admin.HandleStatistics(ctx, msg, repos, botInstance)
+	case admin.ButtonBroadcast:
+		router.Start(fsm.NewContext(ctx, chatID, msg, repos, botInstance), "waiting_for_broadcast_message")
+	case admin.ButtonDBDump:
+		admin.HandleDBDump(ctx, msg, repos, botInstance)
+	case admin.ButtonUsersDump:
+		admin.HandleUsersDump(ctx, msg, repos, botInstance)
+	case admin.ButtonAuditLog:
+		admin.HandleAuditLog(ctx, msg, repos, botInstance)
 	default:
 		msgResponse := tgbotapi.NewMessage(chatID, "Har qanday boshqa xabarlarni shu yerda ko'rib chiqish mumkin")
 		botInstance.Send(msgResponse)
 	}
 }
 
-func handleDocument(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	fileID := msg.Document.FileID
-	fileName := msg.Document.FileName
-	mimeType := msg.Document.MimeType
+func handleTestTitle(c *fsm.Context) (string, error) {
+	title := strings.TrimSpace(c.Text())
 
-	log.Printf("Received document: %s", fileName)
-	err := saveFile(db, botInstance, fileID, fileName, mimeType)
+	testID, err := c.Repos.Tests.Create(c.Ctx, title, c.ChatID)
 	if err != nil {
-		log.Printf("Error saving file: %v", err)
-		msgResponse := tgbotapi.NewMessage(chatID, "Faylni saqlashda xatolik yuz berdi.")
-		botInstance.Send(msgResponse)
-		return
+		return fsm.End, fsm.Fail("Test yaratishda xatolik yuz berdi.", fmt.Errorf("error creating test: %v", err))
 	}
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionCreateTest, audit.Test(testID), "", title)
 
-	stats.UsStats[chatID] = "waiting_for_answers"
-	msgResponse := tgbotapi.NewMessage(chatID, "Fayl muvaffaqiyatli saqlandi. Iltimos, endi javoblarni yuboring:")
-	botInstance.Send(msgResponse)
+	c.Data[dataTestID] = strconv.Itoa(testID)
+	return "waiting_for_test_subject", nil
 }
 
-func handleTestAnswers(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	text := msg.Text
+func validateTestTitle(c *fsm.Context) error {
+	if strings.TrimSpace(c.Text()) == "" {
+		return errors.New("Test nomi bo'sh bo'lmasligi kerak. Iltimos, qaytadan kiriting:")
+	}
+	return nil
+}
 
-	fmt.Println(text)
+func handleTestSubject(c *fsm.Context) (string, error) {
+	subject := strings.TrimSpace(c.Text())
 
-	if storage.IsAdmin(int(chatID), db) {
-		err := storage.AddAnswerToDatabase(db, text)
-		if err != nil {
-			log.Printf("Error adding answer to database: %v", err)
-			msgResponse := tgbotapi.NewMessage(chatID, "Javoblarni qo'shishda xatolik yuz berdi.")
-			botInstance.Send(msgResponse)
-			return
-		}
-		msgResponse := tgbotapi.NewMessage(chatID, "Javoblar muvaffaqiyatli qo'shildi.")
-		botInstance.Send(msgResponse)
-	} else {
-		msgResponse := tgbotapi.NewMessage(chatID, "Faqat admin javoblarni qo'sha oladi.")
-		botInstance.Send(msgResponse)
+	err := c.Repos.Tests.UpdateSubject(c.Ctx, c.Int(dataTestID), subject)
+	if err != nil {
+		return fsm.End, fsm.Fail("Test fanini saqlashda xatolik yuz berdi.", fmt.Errorf("error updating test subject: %v", err))
 	}
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionSetTestSubject, audit.Test(c.Int(dataTestID)), "", subject)
+	return "waiting_for_test_attempts", nil
 }
 
-func handleAnswers(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	userAnswers := msg.Text
+func handleTestAttempts(c *fsm.Context) (string, error) {
+	maxAttempts, _ := strconv.Atoi(strings.TrimSpace(c.Text()))
 
-	log.Printf("Received answers: %s", userAnswers)
+	err := c.Repos.Tests.UpdateMaxAttempts(c.Ctx, c.Int(dataTestID), maxAttempts)
+	if err != nil {
+		return fsm.End, fsm.Fail("Urinishlar sonini saqlashda xatolik yuz berdi.", fmt.Errorf("error updating test attempts: %v", err))
+	}
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionSetTestAttempts, audit.Test(c.Int(dataTestID)), "", strconv.Itoa(maxAttempts))
+	return "waiting_for_test_visibility", nil
+}
 
-	correctAnswers, err := storage.GetCorrectAnswersFromDatabase(db)
+func validateTestAttempts(c *fsm.Context) error {
+	maxAttempts, err := strconv.Atoi(strings.TrimSpace(c.Text()))
+	if err != nil || maxAttempts < 0 {
+		return errors.New("Iltimos, 0 yoki musbat son kiriting:")
+	}
+	return nil
+}
+
+func askTestVisibility(c *fsm.Context) {
+	visibilityKeyboard := tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(visibilityImmediate)),
+		tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(visibilityAfterDeadline)),
+		tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(visibilityManual)),
+	)
+	msgResponse := tgbotapi.NewMessage(c.ChatID, "Natijalar o'quvchilarga qachon ko'rsatilsin?")
+	msgResponse.ReplyMarkup = visibilityKeyboard
+	c.Bot.Send(msgResponse)
+}
+
+const (
+	visibilityImmediate     = "Darhol"
+	visibilityAfterDeadline = "Test yopilgach"
+	visibilityManual        = "Admin e'lon qilganda"
+)
+
+var visibilityOptions = map[string]string{
+	visibilityImmediate:     models.ResultsImmediate,
+	visibilityAfterDeadline: models.ResultsAfterDeadline,
+	visibilityManual:        models.ResultsManual,
+}
+
+func handleTestVisibility(c *fsm.Context) (string, error) {
+	visibility := visibilityOptions[c.Text()]
+
+	err := c.Repos.Tests.UpdateResultsVisibility(c.Ctx, c.Int(dataTestID), visibility)
 	if err != nil {
-		log.Printf("Error getting correct answers: %v", err)
-		msgResponse := tgbotapi.NewMessage(chatID, "Javoblarni tekshirishda xatolik yuz berdi.")
-		botInstance.Send(msgResponse)
-		return
+		return fsm.End, fsm.Fail("Natijalar rejimini saqlashda xatolik yuz berdi.", fmt.Errorf("error updating results visibility: %v", err))
+	}
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionSetTestVisibility, audit.Test(c.Int(dataTestID)), "", visibility)
+	c.Data[dataVisibility] = visibility
+	return "waiting_for_test_schedule", nil
+}
+
+func validateTestVisibility(c *fsm.Context) error {
+	if _, ok := visibilityOptions[c.Text()]; !ok {
+		return errors.New("Iltimos, quyidagi variantlardan birini tanlang:")
 	}
+	return nil
+}
+
+func askTestSchedule(c *fsm.Context) {
+	msgResponse := tgbotapi.NewMessage(c.ChatID, "Test ochilish va yopilish vaqtini hamda bir o'quvchi uchun davomiyligini (daqiqada) yuboring. Cheklov kerak bo'lmasa \"-\" yozing. \n\n Namuna: 2024-05-01 09:00; 2024-05-01 18:00; 90")
+	msgResponse.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	c.Bot.Send(msgResponse)
+}
+
+// Keys of the data attached to conversation states.
+const (
+	dataTestID     = "test_id"
+	dataAnswers    = "answers"
+	dataVisibility = "visibility"
+)
 
-	log.Printf("Correct answers: %s", correctAnswers)
+// scheduleLayout is the format admins use for opening and closing times.
+const scheduleLayout = "2006-01-02 15:04"
 
-	// Do not save userAnswers to the database
-	// Compare userAnswers with correctAnswers instead
-	correctCount, incorrectIndices := checkAnswers(userAnswers, correctAnswers)
+func handleTestSchedule(c *fsm.Context) (string, error) {
+	opensAt, closesAt, duration, _ := parseSchedule(c.Text())
 
-	msgResponse := tgbotapi.NewMessage(chatID, fmt.Sprintf("Javoblaringiz tekshirildi. To'g'ri javoblar soni: %d", correctCount))
-	if len(incorrectIndices) > 0 {
-		msgResponse.Text += fmt.Sprintf("\nNoto'g'ri javoblar: %s", strings.Join(intSliceToStringSlice(incorrectIndices), ", "))
+	err := c.Repos.Tests.UpdateSchedule(c.Ctx, c.Int(dataTestID), opensAt, closesAt, duration)
+	if err != nil {
+		return fsm.End, fsm.Fail("Test vaqtini saqlashda xatolik yuz berdi.", fmt.Errorf("error updating test schedule: %v", err))
 	}
-	botInstance.Send(msgResponse)
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionSetTestSchedule, audit.Test(c.Int(dataTestID)), "", strings.TrimSpace(c.Text()))
+	return "waiting_for_test_file", nil
 }
 
+func validateTestSchedule(c *fsm.Context) error {
+	_, closesAt, _, err := parseSchedule(c.Text())
+	if err != nil {
+		return fmt.Errorf("Noto'g'ri format: %v. Iltimos, qaytadan yuboring:", err)
+	}
+	// Results shown after the deadline are published by the scheduler when it
+	// closes the test, which it only does for tests with a closing time.
+	if closesAt.IsZero() && c.Data[dataVisibility] == models.ResultsAfterDeadline {
+		return errors.New("Natijalar test yopilgach ko'rsatiladi, shuning uchun yopilish vaqtini kiriting. \n\n Namuna: -; 2024-05-01 18:00; -")
+	}
+	return nil
+}
 
-func checkAnswers(userAnswers, correctAnswers string) (int, []int) {
-	userAns := strings.ReplaceAll(userAnswers, "\n", "")
-	correctAns := strings.ReplaceAll(correctAnswers, "\n", "")
+// parseSchedule parses "opens; closes; minutes" where any part may be "-".
+func parseSchedule(text string) (opensAt, closesAt time.Time, duration int, err error) {
+	text = strings.TrimSpace(text)
+	if text == "-" {
+		return
+	}
 
-	count := 0
-	var incorrectIndices []int
-	for i := 0; i < len(userAns) && i < len(correctAns); i++ {
-		if userAns[i] == correctAns[i] {
-			count++
-		} else {
-			incorrectIndices = append(incorrectIndices, i+1) // Indices are 1-based for user readability
+	parts := strings.Split(text, ";")
+	if len(parts) != 3 {
+		err = fmt.Errorf("3 ta qism kerak")
+		return
+	}
+
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+
+	if parts[0] != "-" {
+		if opensAt, err = time.ParseInLocation(scheduleLayout, parts[0], time.Local); err != nil {
+			err = fmt.Errorf("ochilish vaqti %q", parts[0])
+			return
+		}
+	}
+	if parts[1] != "-" {
+		if closesAt, err = time.ParseInLocation(scheduleLayout, parts[1], time.Local); err != nil {
+			err = fmt.Errorf("yopilish vaqti %q", parts[1])
+			return
+		}
+	}
+	if parts[2] != "-" {
+		if duration, err = strconv.Atoi(parts[2]); err != nil || duration < 0 {
+			err = fmt.Errorf("davomiylik %q", parts[2])
+			return
 		}
 	}
 
-	return count, incorrectIndices
+	if !opensAt.IsZero() && !closesAt.IsZero() && !closesAt.After(opensAt) {
+		err = fmt.Errorf("yopilish vaqti ochilish vaqtidan keyin bo'lishi kerak")
+	}
+	return
 }
 
-func saveFile(db *sql.DB, botInstance *tgbotapi.BotAPI, fileID, fileName, mimeType string) error {
-	fileConfig, err := botInstance.GetFile(tgbotapi.FileConfig{FileID: fileID})
+func handleDocument(c *fsm.Context) (string, error) {
+	document := c.Msg.Document
+	testID := c.Int(dataTestID)
+
+	_, oldFileName, err := c.Repos.Files.Get(c.Ctx, testID)
+	if err != nil && err != storage.ErrNotFound {
+		slog.ErrorContext(c.Ctx, "Error getting file from database", "err", err)
+	}
+
+	slog.InfoContext(c.Ctx, "Received document", "file_name", document.FileName)
+	err = saveFile(c.Ctx, c.Repos, c.Bot, testID, document.FileID, document.FileName, document.MimeType)
 	if err != nil {
-		return fmt.Errorf("error getting file config: %v", err)
+		return fsm.End, fsm.Fail("Faylni saqlashda xatolik yuz berdi.", fmt.Errorf("error saving file: %v", err))
 	}
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionUploadTestFile, audit.Test(testID), oldFileName, document.FileName)
+	return "waiting_for_test_answers", nil
+}
+
+func validateDocument(c *fsm.Context) error {
+	if c.Msg.Document == nil {
+		return errors.New("Iltimos, test faylini hujjat sifatida yuboring:")
+	}
+	return nil
+}
 
-	fileURL := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", botInstance.Token, fileConfig.FilePath)
+func handleTestAnswers(c *fsm.Context) (string, error) {
+	testID := c.Int(dataTestID)
 
-	response, err := http.Get(fileURL)
+	oldKey, err := c.Repos.Tests.AnswerKey(c.Ctx, testID)
+	if err != nil && err != storage.ErrNotFound {
+		slog.ErrorContext(c.Ctx, "Error getting correct answers", "err", err)
+	}
+
+	err = c.Repos.Tests.SetAnswerKey(c.Ctx, testID, c.Text())
 	if err != nil {
-		return fmt.Errorf("error downloading file: %v", err)
+		return fsm.End, fsm.Fail("Javoblarni qo'shishda xatolik yuz berdi.", fmt.Errorf("error adding answer to database: %v", err))
 	}
-	defer response.Body.Close()
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionSetAnswerKey, audit.Test(testID), oldKey, c.Text())
 
-	fileData, err := ioutil.ReadAll(response.Body)
+	err = c.Repos.Tests.UpdateStatus(c.Ctx, testID, models.TestStatusActive)
 	if err != nil {
-		return fmt.Errorf("error reading file data: %v", err)
+		return fsm.End, fsm.Fail("Testni faollashtirishda xatolik yuz berdi.", fmt.Errorf("error activating test: %v", err))
+	}
+	audit.Record(c.Ctx, c.Repos.Audit, c.ChatID, audit.ActionSetTestStatus, audit.Test(testID), models.TestStatusDraft, models.TestStatusActive)
+
+	c.Reply("Javoblar muvaffaqiyatli qo'shildi. Test faollashtirildi.")
+	return fsm.End, nil
+}
+
+func validateTestAnswers(c *fsm.Context) error {
+	if _, err := answers.ParseKey(c.Text()); err != nil {
+		return fmt.Errorf("Javoblarni o'qib bo'lmadi: %v. \n\nIltimos, qaytadan yuboring.", err)
 	}
+	return nil
+}
 
-	err = storage.AddFileMetadataToDatabase(db, fileID, fileName, mimeType, fileData)
+func handleAnswers(c *fsm.Context) (string, error) {
+	given, _ := answers.Parse(c.Text())
+
+	encoded, err := json.Marshal(given)
 	if err != nil {
-		return fmt.Errorf("error saving file metadata to database: %v", err)
+		return fsm.End, fsm.Fail("Javoblarni tekshirishda xatolik yuz berdi.", fmt.Errorf("error encoding answers: %v", err))
 	}
 
+	c.Data[dataAnswers] = string(encoded)
+	return "waiting_for_answers_confirmation", nil
+}
+
+func validateAnswers(c *fsm.Context) error {
+	testID := c.Int(dataTestID)
+
+	key, err := getAnswerKey(c.Ctx, testID, c.Repos.Tests)
+	if err != nil {
+		slog.ErrorContext(c.Ctx, "Error getting correct answers", "err", err)
+		return errors.New("Javoblarni tekshirishda xatolik yuz berdi.")
+	}
+
+	given, err := answers.Parse(c.Text())
+	// The answers themselves are the user's work and stay out of the logs
+	slog.DebugContext(c.Ctx, "Received answers for test", "test_id", testID, "answer_count", len(given))
+	if err == nil {
+		err = answers.Validate(given, len(key.Questions))
+	}
+	if err != nil {
+		return fmt.Errorf("Javoblarni o'qib bo'lmadi: %v. \n\nIltimos, qaytadan yuboring.", err)
+	}
 	return nil
 }
 
-func downloadFile(botInstance *tgbotapi.BotAPI, fileID string) ([]byte, error) {
-	fileConfig, err := botInstance.GetFile(tgbotapi.FileConfig{FileID: fileID})
+func askAnswersConfirmation(c *fsm.Context) {
+	var given []string
+	if err := json.Unmarshal([]byte(c.Data[dataAnswers]), &given); err != nil {
+		slog.ErrorContext(c.Ctx, "Error decoding answers", "err", err)
+	}
+
+	msgResponse := tgbotapi.NewMessage(c.ChatID, fmt.Sprintf("Javoblaringiz:\n%s\n\nTasdiqlaysizmi?", answers.Format(given)))
+	msgResponse.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Tasdiqlash", "confirm_answers"),
+			tgbotapi.NewInlineKeyboardButtonData("Qayta yuborish", "retry_answers"),
+		),
+	)
+	c.Bot.Send(msgResponse)
+}
+
+func handleRetryAnswers(ctx context.Context, chatID int64, messageID int, repos *storage.Repos, botInstance messenger.Messenger) {
+	// Delete the previous message
+	botInstance.Delete(chatID, messageID)
+
+	current := state.Get(ctx, chatID)
+	if current.Name != "waiting_for_answers_confirmation" {
+		return
+	}
+
+	c := fsm.NewContext(ctx, chatID, nil, repos, botInstance)
+	c.Data[dataTestID] = current.Data[dataTestID]
+	router.Start(c, "waiting_for_answers")
+}
+
+func handleConfirmAnswers(ctx context.Context, chatID int64, messageID int, repos *storage.Repos, botInstance messenger.Messenger) {
+	// Delete the previous message
+	botInstance.Delete(chatID, messageID)
+
+	current := state.Get(ctx, chatID)
+	if current.Name != "waiting_for_answers_confirmation" {
+		return
+	}
+	testID := current.Int(dataTestID)
+	state.Delete(ctx, chatID)
+
+	var given []string
+	if err := json.Unmarshal([]byte(current.Data[dataAnswers]), &given); err != nil {
+		slog.ErrorContext(ctx, "Error decoding answers", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Javoblarni tekshirishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	test, ok := getActiveTest(ctx, chatID, testID, repos, botInstance)
+	if !ok || !isWithinSession(ctx, chatID, test, repos, botInstance) || !hasAttemptsLeft(ctx, chatID, test, repos, botInstance) {
+		return
+	}
+
+	key, err := getAnswerKey(ctx, testID, repos.Tests)
 	if err != nil {
-		return nil, fmt.Errorf("error getting file config: %v", err)
+		slog.ErrorContext(ctx, "Error getting correct answers", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Javoblarni tekshirishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
 	}
 
-	fileURL := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", botInstance.Token, fileConfig.FilePath)
-	response, err := http.Get(fileURL)
+	result := answers.Grade(key, given)
+
+	submission := models.Submission{
+		UserID:   chatID,
+		TestID:   testID,
+		Answers:  answers.Format(given),
+		Correct:  result.Correct,
+		Score:    result.Score,
+		MaxScore: result.MaxScore,
+	}
+	submission.ID, err = repos.Submissions.Add(ctx, submission)
 	if err != nil {
-		return nil, fmt.Errorf("error downloading file: %v", err)
+		slog.ErrorContext(ctx, "Error saving submission", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Javoblarni saqlashda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
 	}
-	defer response.Body.Close()
+	metrics.Submissions.Inc(strconv.Itoa(testID))
 
-	fileBytes, err := ioutil.ReadAll(response.Body)
+	if err := repos.Users.UpdateRate(ctx, chatID); err != nil {
+		slog.ErrorContext(ctx, "Error updating user rate", "err", err)
+	}
+
+	// Keep the key secret until the results of the test are visible
+	if !test.ResultsVisible() {
+		msgResponse := tgbotapi.NewMessage(chatID, results.Pending(test))
+		botInstance.Send(msgResponse)
+		return
+	}
+	msgResponse := tgbotapi.NewMessage(chatID, results.Breakdown(test, submission))
+	msgResponse.ReplyMarkup = certificate.Button(testID)
+	botInstance.Send(msgResponse)
+}
+
+func getAnswerKey(ctx context.Context, testID int, tests storage.TestRepo) (answers.Key, error) {
+	correctAnswers, err := tests.AnswerKey(ctx, testID)
 	if err != nil {
-		return nil, fmt.Errorf("error reading file data: %v", err)
+		return answers.Key{}, err
 	}
+	return answers.ParseKey(correctAnswers)
+}
 
-	return fileBytes, nil
+// getActiveTest loads the test and tells the user when it no longer accepts answers.
+func getActiveTest(ctx context.Context, chatID int64, testID int, repos *storage.Repos, botInstance messenger.Messenger) (models.Test, bool) {
+	test, err := repos.Tests.Get(ctx, testID)
+	if err != nil || !test.IsOpen(time.Now()) {
+		slog.WarnContext(ctx, "Test is not available", "test_id", testID, "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Bu test hozir mavjud emas.")
+		botInstance.Send(msg)
+		return test, false
+	}
+	return test, true
 }
 
-func isUserSubscribedToChannels(chatID int64, channels []string, botInstance *tgbotapi.BotAPI) bool {
+// sessionDeadline returns when a session started at the given time must end,
+// or zero if neither the test duration nor its closing time limit it.
+func sessionDeadline(test models.Test, startedAt time.Time) time.Time {
+	var deadline time.Time
+	if test.DurationMinutes > 0 {
+		deadline = startedAt.Add(time.Duration(test.DurationMinutes) * time.Minute)
+	}
+	if !test.ClosesAt.IsZero() && (deadline.IsZero() || test.ClosesAt.Before(deadline)) {
+		deadline = test.ClosesAt
+	}
+	return deadline
+}
+
+// isWithinSession tells the user when their time for the test is over.
+func isWithinSession(ctx context.Context, chatID int64, test models.Test, repos *storage.Repos, botInstance messenger.Messenger) bool {
+	session, err := repos.Sessions.Get(ctx, chatID, test.ID)
+	if err == storage.ErrNotFound {
+		if test.DurationMinutes == 0 {
+			return true
+		}
+		msg := tgbotapi.NewMessage(chatID, "Avval test faylini oling.")
+		botInstance.Send(msg)
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting test session", "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Javoblarni tekshirishda xatolik yuz berdi.")
+		botInstance.Send(msg)
+		return false
+	}
+
+	if session.Expired(time.Now()) {
+		msg := tgbotapi.NewMessage(chatID, "Kechirasiz, test uchun ajratilgan vaqt tugagan.")
+		botInstance.Send(msg)
+		return false
+	}
+	return true
+}
+
+// hasAttemptsLeft tells the user when the test's attempt limit is reached.
+func hasAttemptsLeft(ctx context.Context, chatID int64, test models.Test, repos *storage.Repos, botInstance messenger.Messenger) bool {
+	if test.MaxAttempts == 0 {
+		return true
+	}
+
+	attempts, err := repos.Submissions.CountAttempts(ctx, chatID, test.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error counting attempts", "err", err)
+		msg := tgbotapi.NewMessage(chatID, "Javoblarni tekshirishda xatolik yuz berdi.")
+		botInstance.Send(msg)
+		return false
+	}
+
+	if attempts >= test.MaxAttempts {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Siz bu test uchun barcha urinishlardan foydalangansiz (%d/%d).", attempts, test.MaxAttempts))
+		botInstance.Send(msg)
+		return false
+	}
+
+	return true
+}
+
+func saveFile(ctx context.Context, repos *storage.Repos, botInstance messenger.Messenger, testID int, fileID, fileName, mimeType string) error {
+	fileData, err := botInstance.Download(ctx, fileID)
+	if err != nil {
+		return err
+	}
+
+	err = repos.Files.Save(ctx, testID, fileID, fileName, mimeType, fileData)
+	if err != nil {
+		return fmt.Errorf("error saving file metadata to database: %v", err)
+	}
+
+	return nil
+}
+
+func isUserSubscribedToChannels(ctx context.Context, chatID int64, channels []string, botInstance messenger.Messenger) bool {
 	for _, channel := range channels {
-		log.Printf("Checking subscription to channel: %s", channel)
+		slog.DebugContext(ctx, "Checking subscription to channel", "channel", channel)
 		chat, err := botInstance.GetChat(tgbotapi.ChatConfig{SuperGroupUsername: "@" + channel})
 		if err != nil {
-			log.Printf("Error getting chat info for channel %s: %v", channel, err)
+			slog.ErrorContext(ctx, "Error getting chat info for channel", "channel", channel, "err", err)
 			return false
 		}
 
@@ -486,11 +1075,11 @@ func isUserSubscribedToChannels(chatID int64, channels []string, botInstance *tg
 			UserID: int(chatID),
 		})
 		if err != nil {
-			log.Printf("Error getting chat member info for channel %s: %v", channel, err)
+			slog.ErrorContext(ctx, "Error getting chat member info for channel", "channel", channel, "err", err)
 			return false
 		}
 		if member.Status == "left" || member.Status == "kicked" {
-			log.Printf("User %d is not subscribed to channel %s", chatID, channel)
+			slog.InfoContext(ctx, "User is not subscribed to channel", "chat_id", chatID, "channel", channel)
 			return false
 		}
 	}
@@ -509,11 +1098,3 @@ func createSubscriptionKeyboard(channels []string) tgbotapi.InlineKeyboardMarkup
 
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
-
-func intSliceToStringSlice(intSlice []int) []string {
-	var stringSlice []string
-	for _, val := range intSlice {
-		stringSlice = append(stringSlice, fmt.Sprint(val))
-	}
-	return stringSlice
-}
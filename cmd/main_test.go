@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"tgbot/admin"
+)
+
+func TestHandlerName(t *testing.T) {
+	tests := map[string]string{
+		"/start":                        "/start",
+		"/start ref42":                  "/start",
+		"/top tuman=Chilonzor":          "/top",
+		"/cancel":                       "/cancel",
+		"/whatever":                     "other",
+		"/x" + strings.Repeat("x", 100): "other",
+		admin.ButtonBroadcast:           admin.ButtonBroadcast,
+		"Aliyev Vali":                   "message",
+		"1a 2b 3c":                      "message",
+	}
+	for text, want := range tests {
+		if got := handlerName(text); got != want {
+			t.Errorf("handlerName(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestCallbackName(t *testing.T) {
+	tests := map[string]string{
+		"start_test":                "callback start_test",
+		"start_test_12":             "callback start_test",
+		"top_all":                   "callback top_all",
+		"top_3":                     "callback top",
+		"delete_channel_@news":      "callback delete_channel",
+		"confirm_delete_channel_@x": "callback confirm_delete_channel",
+		"audit_page_2":              "callback audit_page",
+		"forged":                    "callback unknown",
+	}
+	for data, want := range tests {
+		if got := callbackName(data); got != want {
+			t.Errorf("callbackName(%q) = %q, want %q", data, got, want)
+		}
+	}
+}
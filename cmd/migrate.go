@@ -0,0 +1,70 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"tgbot/migration"
+)
+
+const migrateUsage = "usage: migrate [up | down [steps] | status]"
+
+// runMigrate handles the migrate subcommand. Without arguments it applies
+// every pending migration, like the bot does on start.
+func runMigrate(db *sql.DB, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+		applied, err := migration.Up(db)
+		for _, m := range applied {
+			fmt.Printf("Applied %04d %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("steps must be a positive number, got %q", args[1])
+			}
+			steps = n
+		}
+		rolledBack, err := migration.Down(db, steps)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back %04d %s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+		statuses, err := migration.List(db)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied"
+			}
+			fmt.Printf("%04d %-40s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
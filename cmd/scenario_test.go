@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbot/admin"
+	"tgbot/auth"
+	"tgbot/messenger"
+	"tgbot/models"
+	"tgbot/state"
+	"tgbot/storage"
+	"tgbot/telegramtest"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	ownerID   = 1
+	studentID = 2
+	teacherID = 3
+)
+
+// scenario runs updates through the guard and the handlers like main does,
+// against in-memory repositories and the fake Bot API server.
+type scenario struct {
+	t      *testing.T
+	server *telegramtest.Server
+	repos  *storage.Repos
+	handle func(context.Context, tgbotapi.Update)
+}
+
+func newScenario(t *testing.T) *scenario {
+	t.Helper()
+	server := telegramtest.NewServer()
+	botInstance, err := server.Bot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot := messenger.NewTelegram(botInstance, time.Second)
+	repos := storage.NewMemoryRepos()
+	state.Use(state.NewMemoryStore())
+	router = newRouter()
+
+	if err := repos.Admins.Add(context.Background(), ownerID, models.RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+
+	guard := &auth.Guard{
+		Repos:            repos,
+		Bot:              bot,
+		Commands:         admin.Commands,
+		CallbackPrefixes: admin.CallbackPrefixes,
+		StatePermission:  router.Permission,
+	}
+	return &scenario{
+		t:      t,
+		server: server,
+		repos:  repos,
+		handle: guard.Wrap(func(ctx context.Context, update tgbotapi.Update) {
+			handleUpdate(ctx, update, repos, bot)
+		}),
+	}
+}
+
+func (s *scenario) send(update tgbotapi.Update) {
+	s.handle(context.Background(), update)
+}
+
+func (s *scenario) text(userID int, text string) {
+	s.send(s.server.Text(userID, text))
+}
+
+func (s *scenario) press(userID int, data string) {
+	s.send(s.server.Callback(userID, 1, data))
+}
+
+// last returns the last message sent to the user.
+func (s *scenario) last(userID int) telegramtest.Request {
+	s.t.Helper()
+	sent := s.server.Sent(int64(userID))
+	if len(sent) == 0 {
+		s.t.Fatalf("nothing was sent to %d", userID)
+	}
+	return sent[len(sent)-1]
+}
+
+// expect fails unless the last message sent to the user contains want.
+func (s *scenario) expect(userID int, want string) {
+	s.t.Helper()
+	if got := s.last(userID).Text(); !strings.Contains(got, want) {
+		s.t.Fatalf("last message to %d = %q, want it to contain %q", userID, got, want)
+	}
+}
+
+// createTest runs the test upload flow as the owner and returns the new
+// test's ID.
+func (s *scenario) createTest(maxAttempts int, visibility, key string) int {
+	s.t.Helper()
+	return s.createTestAs(ownerID, maxAttempts, visibility, key)
+}
+
+// createTestAs runs the test upload flow as the given admin.
+func (s *scenario) createTestAs(adminID int, maxAttempts int, visibility, key string) int {
+	s.t.Helper()
+	s.server.AddFile("test-file", "test.pdf", []byte("%PDF savollar"))
+
+	s.text(adminID, admin.ButtonCreateTest)
+	s.expect(adminID, "test nomini kiriting")
+	s.text(adminID, "Matematika 1")
+	s.text(adminID, "Matematika")
+	s.text(adminID, fmt.Sprint(maxAttempts))
+	s.expect(adminID, "Natijalar o'quvchilarga qachon")
+	s.text(adminID, visibility)
+	s.text(adminID, "-")
+	s.expect(adminID, "test faylini yuklang")
+	s.send(s.server.Document(adminID, "test-file", "test.pdf", "application/pdf"))
+	s.text(adminID, key)
+	s.expect(adminID, "Test faollashtirildi")
+
+	tests, err := s.repos.Tests.Active(context.Background())
+	if err != nil || len(tests) == 0 {
+		s.t.Fatalf("no active test after upload: %v", err)
+	}
+	return tests[len(tests)-1].ID
+}
+
+func TestRegistration(t *testing.T) {
+	s := newScenario(t)
+
+	s.text(studentID, "/start")
+	s.expect(studentID, "ism va familyangizni kiriting")
+
+	s.text(studentID, "Vali Aliyev")
+	s.expect(studentID, "viloyatingizni")
+	s.text(studentID, "Toshkent")
+	s.expect(studentID, "tumaningizni")
+	s.text(studentID, "Chilonzor")
+	s.text(studentID, "68")
+	s.expect(studentID, "sinfingizni")
+
+	// /back returns to the previous question without losing the answers
+	s.text(studentID, "/back")
+	s.expect(studentID, "maktabingizni")
+	s.text(studentID, "68")
+	s.text(studentID, "10")
+	s.expect(studentID, "telefon raqamingizni")
+	s.send(s.server.Contact(studentID, "+998901234567"))
+
+	sent := s.server.Sent(studentID)
+	if len(sent) < 2 || !strings.Contains(sent[len(sent)-2].Text(), "muvaffaqiyatli yakunlandi") {
+		t.Fatalf("registration did not finish: %q", s.server.Texts(studentID))
+	}
+	if data := s.last(studentID).CallbackData(); len(data) != 1 || data[0] != "start_test" {
+		t.Errorf("start button data = %q", data)
+	}
+
+	user, err := s.repos.Users.Get(context.Background(), studentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.User{ID: studentID, FullName: "Vali Aliyev", Region: "Toshkent", District: "Chilonzor", School: "68", Grade: "10", Phone: "+998901234567"}
+	if user.FullName != want.FullName || user.Region != want.Region || user.District != want.District ||
+		user.School != want.School || user.Grade != want.Grade || user.Phone != want.Phone {
+		t.Errorf("user = %+v, want %+v", user, want)
+	}
+	if current := state.Get(context.Background(), studentID); current.Name != "" {
+		t.Errorf("state after registration = %q", current.Name)
+	}
+}
+
+func TestRegistrationResumesAfterCancel(t *testing.T) {
+	s := newScenario(t)
+
+	s.text(studentID, "/start")
+	s.text(studentID, "Vali Aliyev")
+	s.text(studentID, "Toshkent")
+	s.expect(studentID, "tumaningizni")
+	s.text(studentID, "/cancel")
+	s.expect(studentID, "bekor qilindi")
+
+	// The answers given so far are kept and /start asks for the next one
+	s.text(studentID, "/start")
+	s.expect(studentID, "tumaningizni")
+	s.text(studentID, "Chilonzor")
+	s.text(studentID, "68")
+	s.text(studentID, "10")
+	s.send(s.server.Contact(studentID, "+998901234567"))
+
+	user, err := s.repos.Users.Get(context.Background(), studentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FullName != "Vali Aliyev" || user.Region != "Toshkent" || user.District != "Chilonzor" || user.Phone != "+998901234567" {
+		t.Errorf("user = %+v", user)
+	}
+
+	s.text(studentID, "/start")
+	s.expect(studentID, "xush kelibsiz")
+}
+
+func TestRegistrationRequiresSubscription(t *testing.T) {
+	s := newScenario(t)
+	s.repos.Channels.Add(context.Background(), "news")
+	s.server.AddChat("@news", -100)
+
+	s.text(studentID, "/start")
+	s.expect(studentID, "kanallarga")
+
+	s.server.SetMember(-100, studentID, "member")
+	s.press(studentID, "check_subscription")
+	s.expect(studentID, "ism va familyangizni kiriting")
+}
+
+func TestSubmitAnswers(t *testing.T) {
+	s := newScenario(t)
+	testID := s.createTest(1, visibilityImmediate, "abc")
+	s.repos.Users.Add(context.Background(), studentID)
+
+	s.press(studentID, "start_test")
+	if data := s.last(studentID).CallbackData(); len(data) != 1 || data[0] != fmt.Sprintf("start_test_%d", testID) {
+		t.Fatalf("test list buttons = %q", data)
+	}
+
+	s.press(studentID, fmt.Sprintf("start_test_%d", testID))
+	var document *telegramtest.Request
+	for _, req := range s.server.Sent(studentID) {
+		if req.Method == "sendDocument" {
+			document = &req
+		}
+	}
+	if document == nil || document.Upload == nil || string(document.Upload.Data) != "%PDF savollar" {
+		t.Fatalf("test file was not sent: %+v", document)
+	}
+
+	s.press(studentID, fmt.Sprintf("check_answers_%d", testID))
+	s.expect(studentID, "javoblaringizni yuboring")
+
+	s.text(studentID, "1a 2b")
+	s.expect(studentID, "quyidagi savollarga javob berilmagan: 3")
+	s.text(studentID, "1a 2000000000b")
+	s.expect(studentID, "noto'g'ri savol raqami")
+
+	s.text(studentID, "abd")
+	s.expect(studentID, "Tasdiqlaysizmi?")
+	s.press(studentID, "confirm_answers")
+
+	best, err := s.repos.Submissions.Best(context.Background(), testID)
+	if err != nil || len(best) != 1 {
+		t.Fatalf("submissions = %+v, %v", best, err)
+	}
+	if best[0].Score != 2 || best[0].MaxScore != 3 {
+		t.Errorf("score = %v of %v, want 2 of 3", best[0].Score, best[0].MaxScore)
+	}
+
+	// The only attempt is used up
+	s.press(studentID, fmt.Sprintf("check_answers_%d", testID))
+	if current := state.Get(context.Background(), studentID); current.Name != "" {
+		t.Errorf("second attempt started in state %q", current.Name)
+	}
+}
+
+func TestResultsAfterDeadlineNeedClosingTime(t *testing.T) {
+	s := newScenario(t)
+
+	s.text(ownerID, admin.ButtonCreateTest)
+	s.text(ownerID, "Matematika 1")
+	s.text(ownerID, "Matematika")
+	s.text(ownerID, "1")
+	s.text(ownerID, visibilityAfterDeadline)
+	s.text(ownerID, "-")
+	s.expect(ownerID, "yopilish vaqtini kiriting")
+	s.text(ownerID, "-; 2030-05-01 18:00; -")
+	s.expect(ownerID, "test faylini yuklang")
+}
+
+func TestAnswersStayOutOfLogs(t *testing.T) {
+	s := newScenario(t)
+	testID := s.createTest(0, visibilityImmediate, "abc")
+	s.repos.Users.Add(context.Background(), studentID)
+
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	s.press(studentID, fmt.Sprintf("check_answers_%d", testID))
+	s.text(studentID, "1-qwe 2-rty 3-uio")
+	s.text(studentID, "1-qwe 2-rty")
+	if strings.Contains(logs.String(), "qwe") {
+		t.Errorf("submitted answers were logged: %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), `"answer_count":3`) {
+		t.Errorf("answer count was not logged: %s", logs.String())
+	}
+}
+
+func TestHiddenResultsStayOffTheLeaderboard(t *testing.T) {
+	s := newScenario(t)
+	testID := s.createTest(0, visibilityManual, "abc")
+	s.repos.Users.Add(context.Background(), studentID)
+
+	s.press(studentID, fmt.Sprintf("check_answers_%d", testID))
+	s.text(studentID, "abd")
+	s.press(studentID, "confirm_answers")
+	s.expect(studentID, "admin e'lon qilganidan keyin")
+
+	s.text(studentID, "/top")
+	s.expect(studentID, "1. ID 2 — 0")
+}
+
+func TestAdminFlowNeedsPermission(t *testing.T) {
+	s := newScenario(t)
+	s.repos.Users.Add(context.Background(), studentID)
+
+	s.text(studentID, admin.ButtonBroadcast)
+	s.expect(studentID, "Siz admin emassiz.")
+	if current := state.Get(context.Background(), studentID); current.Name != "" {
+		t.Fatalf("student entered state %q", current.Name)
+	}
+
+	// A leftover state the router no longer knows does not open admin buttons
+	state.Set(context.Background(), studentID, "removed_state", nil, time.Hour)
+	s.text(studentID, admin.ButtonBroadcast)
+	s.expect(studentID, "Siz admin emassiz.")
+
+	s.text(ownerID, admin.ButtonAddAdmin)
+	s.text(ownerID, fmt.Sprint(studentID))
+	role, err := s.repos.Admins.Role(context.Background(), studentID)
+	if err != nil {
+		t.Fatalf("admin was not added: %v (messages %q)", err, s.server.Texts(ownerID))
+	}
+	if role != models.RoleAdmin {
+		t.Errorf("role = %q, want %q", role, models.RoleAdmin)
+	}
+}
+
+func TestCertificateWaitsForTestToClose(t *testing.T) {
+	s := newScenario(t)
+	testID := s.createTest(0, visibilityImmediate, "abc")
+	ctx := context.Background()
+	s.repos.Users.Add(ctx, studentID)
+	s.repos.Users.UpdateFullName(ctx, studentID, "Vali Aliyev")
+
+	s.press(studentID, fmt.Sprintf("check_answers_%d", testID))
+	s.text(studentID, "abd")
+	s.press(studentID, "confirm_answers")
+	if data := s.last(studentID).CallbackData(); len(data) != 1 || data[0] != fmt.Sprintf("certificate_%d", testID) {
+		t.Fatalf("breakdown buttons = %q", data)
+	}
+
+	s.press(studentID, fmt.Sprintf("certificate_%d", testID))
+	s.expect(studentID, "test yopilgandan keyin")
+
+	s.press(ownerID, fmt.Sprintf("toggle_test_%d", testID))
+	s.press(studentID, fmt.Sprintf("certificate_%d", testID))
+	if last := s.last(studentID); last.Method != "sendDocument" || last.Upload == nil {
+		t.Fatalf("certificate was not sent, last message %q", last.Text())
+	}
+}
+
+func TestTeacherManagesOnlyOwnTests(t *testing.T) {
+	s := newScenario(t)
+	ctx := context.Background()
+	if err := s.repos.Admins.Add(ctx, teacherID, models.RoleTeacher); err != nil {
+		t.Fatal(err)
+	}
+	ownerTest := s.createTest(0, visibilityManual, "abc")
+
+	s.text(teacherID, admin.ButtonTests)
+	s.expect(teacherID, "testlar yo'q")
+
+	s.press(teacherID, fmt.Sprintf("toggle_test_%d", ownerTest))
+	s.expect(teacherID, "o'zingiz yaratgan testlarni")
+	s.press(teacherID, fmt.Sprintf("publish_results_%d", ownerTest))
+	s.expect(teacherID, "o'zingiz yaratgan testlarni")
+	s.text(teacherID, admin.ButtonResetAttempts)
+	s.text(teacherID, fmt.Sprintf("%d %d", studentID, ownerTest))
+	s.expect(teacherID, "o'zingiz yaratgan testlarni")
+
+	test, err := s.repos.Tests.Get(ctx, ownerTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if test.Status != models.TestStatusActive || test.ResultsPublished {
+		t.Errorf("teacher changed the owner's test: %+v", test)
+	}
+
+	// The certificate template is shared by every test
+	s.text(teacherID, admin.ButtonCertificateTemplate)
+	s.expect(teacherID, "ruxsat yo'q")
+
+	teacherTest := s.createTestAs(teacherID, 0, visibilityManual, "abc")
+	s.text(teacherID, admin.ButtonTests)
+	if data := s.last(teacherID).CallbackData(); len(data) != 2 || data[0] != fmt.Sprintf("toggle_test_%d", teacherTest) {
+		t.Errorf("teacher's test list buttons = %q", data)
+	}
+	s.press(teacherID, fmt.Sprintf("toggle_test_%d", teacherTest))
+	if test, _ := s.repos.Tests.Get(ctx, teacherTest); test.Status != models.TestStatusClosed {
+		t.Errorf("teacher could not close their own test, status %q", test.Status)
+	}
+
+	// Admins manage every test
+	s.press(ownerID, fmt.Sprintf("toggle_test_%d", teacherTest))
+	if test, _ := s.repos.Tests.Get(ctx, teacherTest); test.Status != models.TestStatusActive {
+		t.Errorf("owner could not reopen the teacher's test, status %q", test.Status)
+	}
+}
@@ -3,8 +3,14 @@ package config
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"tgbot/metrics"
 	"tgbot/models"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	_ "github.com/lib/pq"
@@ -15,10 +21,7 @@ var bot *tgbotapi.BotAPI
 
 // InitializeDatabase sets up a connection to PostgreSQL database
 func InitializeDatabase(dbConfig models.DB) (*sql.DB, error) {
-	dbInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.Name)
-
-	dbConn, err := sql.Open("postgres", dbInfo)
+	dbConn, err := sql.Open("postgres", dataSourceName(dbConfig))
 	if err != nil {
 		return nil, fmt.Errorf("error opening database connection: %v", err)
 	}
@@ -32,9 +35,26 @@ func InitializeDatabase(dbConfig models.DB) (*sql.DB, error) {
 	return dbConn, nil
 }
 
-// InitializeBot creates a new Telegram bot instance
-func InitializeBot(botToken string) (*tgbotapi.BotAPI, error) {
-	botInstance, err := tgbotapi.NewBotAPI(botToken)
+// dataSourceName returns the postgres:// URL of the database. Every part is
+// escaped, so passwords and names may contain spaces, quotes or @.
+func dataSourceName(dbConfig models.DB) string {
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(dbConfig.User, dbConfig.Password),
+		Host:   net.JoinHostPort(dbConfig.Host, strconv.Itoa(dbConfig.Port)),
+		Path:   "/" + dbConfig.Name,
+	}
+	if dbConfig.SSLMode != "" {
+		dsn.RawQuery = url.Values{"sslmode": {dbConfig.SSLMode}}.Encode()
+	}
+	return dsn.String()
+}
+
+// InitializeBot creates a new Telegram bot instance whose requests give up
+// after timeout and are counted in the metrics when they fail
+func InitializeBot(botToken string, timeout time.Duration) (*tgbotapi.BotAPI, error) {
+	client := &http.Client{Timeout: timeout, Transport: metrics.TelegramTransport{}}
+	botInstance, err := tgbotapi.NewBotAPIWithClient(botToken, client)
 	if err != nil {
 		return nil, fmt.Errorf("error creating new bot instance: %v", err)
 	}
@@ -53,7 +73,7 @@ func GetBot() *tgbotapi.BotAPI {
 }
 
 // Setup initializes database and bot instances
-func Setup(dbConfig models.DB, botToken string) error {
+func Setup(dbConfig models.DB, botToken string, botTimeout time.Duration) error {
 	// Initialize database
 	dbConn, err := InitializeDatabase(dbConfig)
 	if err != nil {
@@ -62,12 +82,12 @@ func Setup(dbConfig models.DB, botToken string) error {
 	db = dbConn
 
 	// Initialize bot
-	botInstance, err := InitializeBot(botToken)
+	botInstance, err := InitializeBot(botToken, botTimeout)
 	if err != nil {
 		return fmt.Errorf("failed to initialize bot: %v", err)
 	}
 	bot = botInstance
 
-	log.Println("Database and bot initialized successfully")
+	slog.Info("Database and bot initialized successfully")
 	return nil
 }
@@ -0,0 +1,343 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"tgbot/models"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds everything the bot needs at startup.
+type Config struct {
+	DB       models.DB `yaml:"db"`
+	BotToken string    `yaml:"bot_token"`
+	// Owners get the owner role on every start, so they can always manage
+	// the other admins.
+	Owners []int64 `yaml:"owners"`
+	// Admins are added with the admin role on every start unless they
+	// already have a role.
+	Admins []int64 `yaml:"admins"`
+	// BroadcastRate is the number of messages per second sent by broadcasts.
+	BroadcastRate int `yaml:"broadcast_rate"`
+	// DumpDir is where database and user dumps are written before sending.
+	DumpDir string `yaml:"dump_dir"`
+	// Mode is either "polling" or "webhook".
+	Mode    string  `yaml:"mode"`
+	Webhook Webhook `yaml:"webhook"`
+	// Workers is the number of updates handled in parallel. Updates from one
+	// chat are always handled in order. Chats are spread over the workers by
+	// their ID, so a chat whose updates are slow to handle also holds up the
+	// other chats that share its worker.
+	Workers int `yaml:"workers"`
+	// QueueSize is how many updates may wait for each worker before receiving
+	// new updates is paused.
+	QueueSize int `yaml:"queue_size"`
+	// StateStore is where conversation states are kept: "postgres" keeps them
+	// across restarts, "memory" forgets them.
+	StateStore string   `yaml:"state_store"`
+	Timeouts   Timeouts `yaml:"timeouts"`
+	// LogLevel is the lowest level logged: debug, info, warn or error.
+	LogLevel string `yaml:"log_level"`
+	// MetricsListen is the address serving Prometheus metrics at /metrics.
+	// Empty turns the metrics server off.
+	MetricsListen string `yaml:"metrics_listen"`
+}
+
+// Timeouts bound how long work may take before it is abandoned, so that a
+// hung database or Telegram request cannot block a worker for good.
+type Timeouts struct {
+	// Update bounds handling one update, queries and Telegram calls included.
+	Update time.Duration `yaml:"update"`
+	// Query bounds a single database query.
+	Query time.Duration `yaml:"query"`
+	// Telegram bounds a single Bot API request.
+	Telegram time.Duration `yaml:"telegram"`
+	// Download bounds fetching a file sent to the bot.
+	Download time.Duration `yaml:"download"`
+	// Shutdown is how long queued updates may still be handled after a stop
+	// signal before the work in progress is cancelled.
+	Shutdown time.Duration `yaml:"shutdown"`
+}
+
+// Webhook configures the embedded server receiving updates in webhook mode.
+type Webhook struct {
+	// URL is the public HTTPS address Telegram posts updates to. Its path is
+	// also the path served locally.
+	URL         string `yaml:"url"`
+	Listen      string `yaml:"listen"`
+	SecretToken string `yaml:"secret_token"`
+	// CertFile and KeyFile enable TLS on the embedded server. Leave them empty
+	// when a reverse proxy terminates HTTPS.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+const (
+	ModePolling = "polling"
+	ModeWebhook = "webhook"
+)
+
+const (
+	StateStoreMemory   = "memory"
+	StateStorePostgres = "postgres"
+)
+
+var secretTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+var current = Default()
+
+// Default returns the configuration used for values not set anywhere else.
+func Default() Config {
+	return Config{
+		DB: models.DB{
+			Host:    "localhost",
+			Port:    5432,
+			SSLMode: "disable",
+		},
+		BroadcastRate: 5,
+		DumpDir:       os.TempDir(),
+		Mode:          ModePolling,
+		Webhook: Webhook{
+			Listen: ":8443",
+		},
+		Workers:    8,
+		QueueSize:  100,
+		StateStore: StateStorePostgres,
+		Timeouts: Timeouts{
+			Update:   time.Minute,
+			Query:    10 * time.Second,
+			Telegram: 30 * time.Second,
+			Download: time.Minute,
+			Shutdown: 30 * time.Second,
+		},
+		LogLevel: "info",
+	}
+}
+
+// Get returns the configuration loaded by Load, or the defaults before that.
+func Get() Config {
+	return current
+}
+
+// Load reads the optional YAML file at path, applies TGBOT_* environment
+// variables on top of it and validates the result. An empty path falls back
+// to the TGBOT_CONFIG environment variable.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv("TGBOT_CONFIG")
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("error reading config file: %v", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("error parsing config file %s: %v", path, err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+
+	current = cfg
+	return cfg, nil
+}
+
+func applyEnv(cfg *Config) error {
+	stringVars := map[string]*string{
+		"TGBOT_BOT_TOKEN":            &cfg.BotToken,
+		"TGBOT_DB_HOST":              &cfg.DB.Host,
+		"TGBOT_DB_USER":              &cfg.DB.User,
+		"TGBOT_DB_PASSWORD":          &cfg.DB.Password,
+		"TGBOT_DB_NAME":              &cfg.DB.Name,
+		"TGBOT_DB_SSLMODE":           &cfg.DB.SSLMode,
+		"TGBOT_DUMP_DIR":             &cfg.DumpDir,
+		"TGBOT_MODE":                 &cfg.Mode,
+		"TGBOT_WEBHOOK_URL":          &cfg.Webhook.URL,
+		"TGBOT_WEBHOOK_LISTEN":       &cfg.Webhook.Listen,
+		"TGBOT_WEBHOOK_SECRET_TOKEN": &cfg.Webhook.SecretToken,
+		"TGBOT_WEBHOOK_CERT_FILE":    &cfg.Webhook.CertFile,
+		"TGBOT_WEBHOOK_KEY_FILE":     &cfg.Webhook.KeyFile,
+		"TGBOT_STATE_STORE":          &cfg.StateStore,
+		"TGBOT_LOG_LEVEL":            &cfg.LogLevel,
+		"TGBOT_METRICS_LISTEN":       &cfg.MetricsListen,
+	}
+	for name, target := range stringVars {
+		if value, ok := os.LookupEnv(name); ok {
+			*target = value
+		}
+	}
+
+	intVars := map[string]*int{
+		"TGBOT_DB_PORT":        &cfg.DB.Port,
+		"TGBOT_BROADCAST_RATE": &cfg.BroadcastRate,
+		"TGBOT_WORKERS":        &cfg.Workers,
+		"TGBOT_QUEUE_SIZE":     &cfg.QueueSize,
+	}
+	for name, target := range intVars {
+		if value, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a number, got %q", name, value)
+			}
+			*target = n
+		}
+	}
+
+	durationVars := map[string]*time.Duration{
+		"TGBOT_TIMEOUT_UPDATE":   &cfg.Timeouts.Update,
+		"TGBOT_TIMEOUT_QUERY":    &cfg.Timeouts.Query,
+		"TGBOT_TIMEOUT_TELEGRAM": &cfg.Timeouts.Telegram,
+		"TGBOT_TIMEOUT_DOWNLOAD": &cfg.Timeouts.Download,
+		"TGBOT_TIMEOUT_SHUTDOWN": &cfg.Timeouts.Shutdown,
+	}
+	for name, target := range durationVars {
+		if value, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a duration such as 30s, got %q", name, value)
+			}
+			*target = d
+		}
+	}
+
+	idVars := map[string]*[]int64{
+		"TGBOT_OWNERS": &cfg.Owners,
+		"TGBOT_ADMINS": &cfg.Admins,
+	}
+	for name, target := range idVars {
+		if value, ok := os.LookupEnv(name); ok {
+			ids, err := parseIDs(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			*target = ids
+		}
+	}
+
+	return nil
+}
+
+// parseIDs parses a comma separated list of Telegram user IDs.
+func parseIDs(value string) ([]int64, error) {
+	var ids []int64
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID %q", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Validate reports every missing or invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	if c.BotToken == "" {
+		errs = append(errs, errors.New("bot token is not set (bot_token or TGBOT_BOT_TOKEN)"))
+	}
+	if c.DB.Host == "" {
+		errs = append(errs, errors.New("database host is not set (db.host or TGBOT_DB_HOST)"))
+	}
+	if c.DB.Port <= 0 || c.DB.Port > 65535 {
+		errs = append(errs, fmt.Errorf("database port %d is out of range", c.DB.Port))
+	}
+	if c.DB.User == "" {
+		errs = append(errs, errors.New("database user is not set (db.user or TGBOT_DB_USER)"))
+	}
+	if c.DB.Name == "" {
+		errs = append(errs, errors.New("database name is not set (db.name or TGBOT_DB_NAME)"))
+	}
+	if c.BroadcastRate <= 0 {
+		errs = append(errs, fmt.Errorf("broadcast rate must be positive, got %d", c.BroadcastRate))
+	}
+	if c.Workers <= 0 {
+		errs = append(errs, fmt.Errorf("workers must be positive, got %d", c.Workers))
+	}
+	if c.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("queue size must not be negative, got %d", c.QueueSize))
+	}
+	if c.StateStore != StateStoreMemory && c.StateStore != StateStorePostgres {
+		errs = append(errs, fmt.Errorf("state store must be %q or %q, got %q", StateStoreMemory, StateStorePostgres, c.StateStore))
+	}
+	if t := c.Timeouts; t.Update <= 0 || t.Query <= 0 || t.Telegram <= 0 || t.Download <= 0 || t.Shutdown <= 0 {
+		errs = append(errs, fmt.Errorf("timeouts must be positive, got %+v", t))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("log level must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+	if c.MetricsListen != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsListen); err != nil {
+			errs = append(errs, fmt.Errorf("metrics listen address %q must be host:port, e.g. :9090 (metrics_listen or TGBOT_METRICS_LISTEN)", c.MetricsListen))
+		}
+	}
+	switch c.Mode {
+	case ModePolling:
+	case ModeWebhook:
+		if u, err := url.Parse(c.Webhook.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhook URL %q must be an absolute https URL (webhook.url or TGBOT_WEBHOOK_URL)", c.Webhook.URL))
+		}
+		if !secretTokenPattern.MatchString(c.Webhook.SecretToken) {
+			errs = append(errs, errors.New("webhook secret token must be 1-256 characters of A-Z, a-z, 0-9, _ and - (webhook.secret_token or TGBOT_WEBHOOK_SECRET_TOKEN)"))
+		}
+		if c.Webhook.Listen == "" {
+			errs = append(errs, errors.New("webhook listen address is not set (webhook.listen or TGBOT_WEBHOOK_LISTEN)"))
+		}
+		if (c.Webhook.CertFile == "") != (c.Webhook.KeyFile == "") {
+			errs = append(errs, errors.New("webhook cert_file and key_file must be set together"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("mode must be %q or %q, got %q", ModePolling, ModeWebhook, c.Mode))
+	}
+	for _, id := range c.Owners {
+		if id <= 0 {
+			errs = append(errs, fmt.Errorf("owner ID %d is not a valid user ID", id))
+		}
+	}
+	for _, id := range c.Admins {
+		if id <= 0 {
+			errs = append(errs, fmt.Errorf("admin ID %d is not a valid user ID", id))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %v", errors.Join(errs...))
+	}
+	return nil
+}
+
+// BroadcastInterval is the pause between two messages of a broadcast.
+func (c Config) BroadcastInterval() time.Duration {
+	return time.Second / time.Duration(c.BroadcastRate)
+}
+
+// WebhookPath is the local path the webhook handler is served at.
+func (c Config) WebhookPath() string {
+	u, err := url.Parse(c.Webhook.URL)
+	if err != nil || u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbot/models"
+
+	"github.com/lib/pq"
+)
+
+// validYAML is a complete configuration, so tests only set what they check.
+const validYAML = `
+bot_token: "123:abc"
+db:
+  host: db.local
+  user: bot
+  password: secret
+  name: testbot
+workers: 4
+timeouts:
+  query: 5s
+`
+
+func writeConfig(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, validYAML)
+	t.Setenv("TGBOT_DB_PASSWORD", "from env")
+	t.Setenv("TGBOT_WORKERS", "16")
+	t.Setenv("TGBOT_TIMEOUT_UPDATE", "2m")
+	t.Setenv("TGBOT_ADMINS", "5, 6,")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment overrides the file, which overrides the defaults
+	if cfg.DB.Password != "from env" || cfg.Workers != 16 || cfg.Timeouts.Update != 2*time.Minute {
+		t.Errorf("environment not applied: %+v", cfg)
+	}
+	if cfg.DB.Host != "db.local" || cfg.Timeouts.Query != 5*time.Second {
+		t.Errorf("file not applied: %+v", cfg)
+	}
+	if cfg.DB.Port != 5432 || cfg.QueueSize != 100 || cfg.Mode != ModePolling {
+		t.Errorf("defaults not kept: %+v", cfg)
+	}
+	if len(cfg.Admins) != 2 || cfg.Admins[0] != 5 || cfg.Admins[1] != 6 {
+		t.Errorf("admins = %v, want [5 6]", cfg.Admins)
+	}
+}
+
+func TestLoadConfigFromEnvPath(t *testing.T) {
+	t.Setenv("TGBOT_CONFIG", writeConfig(t, validYAML))
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DB.Host != "db.local" {
+		t.Errorf("TGBOT_CONFIG file not read: %+v", cfg)
+	}
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		env   map[string]string
+		wants []string
+	}{
+		{name: "number", env: map[string]string{"TGBOT_DB_PORT": "54x"}, wants: []string{`TGBOT_DB_PORT must be a number, got "54x"`}},
+		{name: "duration", env: map[string]string{"TGBOT_TIMEOUT_QUERY": "10"}, wants: []string{`TGBOT_TIMEOUT_QUERY must be a duration`}},
+		{name: "user ID", env: map[string]string{"TGBOT_OWNERS": "1,x"}, wants: []string{`TGBOT_OWNERS: invalid user ID "x"`}},
+		{name: "yaml duration", yaml: validYAML + "\n  update: soon\n", wants: []string{"error parsing config file"}},
+		{
+			name: "every problem at once",
+			env: map[string]string{
+				"TGBOT_BOT_TOKEN": "",
+				"TGBOT_WORKERS":   "0",
+				"TGBOT_MODE":      "push",
+				"TGBOT_LOG_LEVEL": "loud",
+			},
+			wants: []string{
+				"invalid configuration",
+				"bot token is not set",
+				"workers must be positive, got 0",
+				`mode must be "polling" or "webhook", got "push"`,
+				`log level must be debug, info, warn or error, got "loud"`,
+			},
+		},
+		{
+			name: "webhook",
+			env:  map[string]string{"TGBOT_MODE": ModeWebhook, "TGBOT_WEBHOOK_URL": "http://example.com/hook"},
+			wants: []string{
+				"must be an absolute https URL",
+				"webhook secret token must be",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := tt.yaml
+			if text == "" {
+				text = validYAML
+			}
+			path := writeConfig(t, text)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := Load(path)
+			if err == nil {
+				t.Fatal("Load succeeded, want an error")
+			}
+			for _, want := range tt.wants {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDataSourceName(t *testing.T) {
+	db := models.DB{
+		Host:     "db.local",
+		Port:     5433,
+		User:     "bot user",
+		Password: `p@ss word's "quoted"/?`,
+		Name:     "test bot",
+		SSLMode:  "require",
+	}
+	dsn := dataSourceName(db)
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := u.User.Password()
+	if u.Scheme != "postgres" || u.User.Username() != db.User || password != db.Password ||
+		u.Host != "db.local:5433" || u.Path != "/test bot" || u.Query().Get("sslmode") != "require" {
+		t.Errorf("dataSourceName = %q", dsn)
+	}
+
+	// The driver accepts it and quotes the values in its own format
+	opts, err := pq.ParseURL(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(opts, `password='p@ss word\'s "quoted"/?'`) {
+		t.Errorf("driver options = %s", opts)
+	}
+}
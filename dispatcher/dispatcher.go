@@ -0,0 +1,166 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// ErrClosed is returned by Submit once the pool has been closed.
+var ErrClosed = errors.New("dispatcher: pool is closed")
+
+// Pool handles updates on a fixed number of workers. Updates of the same chat
+// always go to the same worker, so they are handled one at a time and in the
+// order they were submitted, while different chats are handled in parallel.
+type Pool struct {
+	ctx    context.Context
+	handle func(context.Context, tgbotapi.Update)
+	queues []chan tgbotapi.Update
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New starts workers goroutines, each with a queue holding up to queueSize
+// updates waiting to be handled. Handlers get ctx, so cancelling it stops the
+// work in progress, while Submit and Close are unaffected by it.
+func New(ctx context.Context, workers, queueSize int, handle func(context.Context, tgbotapi.Update)) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := &Pool{
+		ctx:    ctx,
+		handle: handle,
+		queues: make([]chan tgbotapi.Update, workers),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan tgbotapi.Update, queueSize)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+// Submit queues the update for its chat's worker. When that queue is full it
+// blocks until there is room or the context is done, so a slow worker slows
+// down the caller instead of growing memory without bound.
+func (p *Pool) Submit(ctx context.Context, update tgbotapi.Update) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+
+	select {
+	case p.queues[p.shard(ChatID(update))] <- update:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting updates and waits until every queued update has been
+// handled.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (p *Pool) shard(chatID int64) int {
+	if chatID < 0 {
+		chatID = -chatID
+	}
+	return int(chatID % int64(len(p.queues)))
+}
+
+func (p *Pool) work(queue <-chan tgbotapi.Update) {
+	defer p.wg.Done()
+	for update := range queue {
+		p.safeHandle(update)
+	}
+}
+
+// safeHandle keeps a panicking handler from taking down the worker and with it
+// every chat assigned to it.
+func (p *Pool) safeHandle(update tgbotapi.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Panic while handling update", "update_id", update.UpdateID, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	p.handle(p.ctx, update)
+}
+
+// ChatID returns the chat the update belongs to. Updates without a chat are
+// keyed by their sender, and 0 is returned when neither is known.
+func ChatID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.ID
+	case update.ChannelPost != nil:
+		return update.ChannelPost.Chat.ID
+	case update.EditedChannelPost != nil:
+		return update.EditedChannelPost.Chat.ID
+	case update.CallbackQuery != nil:
+		if update.CallbackQuery.Message != nil {
+			return update.CallbackQuery.Message.Chat.ID
+		}
+		if update.CallbackQuery.From != nil {
+			return int64(update.CallbackQuery.From.ID)
+		}
+	case update.InlineQuery != nil && update.InlineQuery.From != nil:
+		return int64(update.InlineQuery.From.ID)
+	case update.ChosenInlineResult != nil && update.ChosenInlineResult.From != nil:
+		return int64(update.ChosenInlineResult.From.ID)
+	case update.ShippingQuery != nil && update.ShippingQuery.From != nil:
+		return int64(update.ShippingQuery.From.ID)
+	case update.PreCheckoutQuery != nil && update.PreCheckoutQuery.From != nil:
+		return int64(update.PreCheckoutQuery.From.ID)
+	}
+	return 0
+}
+
+// Type names the kind of the update, such as "message" or "callback_query",
+// after the field of the Bot API update object that is set.
+func Type(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.ChannelPost != nil:
+		return "channel_post"
+	case update.EditedChannelPost != nil:
+		return "edited_channel_post"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	case update.ChosenInlineResult != nil:
+		return "chosen_inline_result"
+	case update.ShippingQuery != nil:
+		return "shipping_query"
+	case update.PreCheckoutQuery != nil:
+		return "pre_checkout_query"
+	}
+	return "unknown"
+}
@@ -0,0 +1,220 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func message(chatID int64, updateID int) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message:  &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+	}
+}
+
+// wait fails the test if ch is not closed or sent on within a second.
+func wait[T any](t *testing.T, ch <-chan T, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestPoolOrdersUpdatesOfOneChat(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		running = make(map[int64]int)
+		handled = make(map[int64][]int)
+	)
+	pool := New(context.Background(), 4, 10, func(ctx context.Context, update tgbotapi.Update) {
+		chatID := ChatID(update)
+		mu.Lock()
+		running[chatID]++
+		if running[chatID] > 1 {
+			t.Errorf("chat %d has %d updates handled at once", chatID, running[chatID])
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running[chatID]--
+		handled[chatID] = append(handled[chatID], update.UpdateID)
+		mu.Unlock()
+	})
+
+	for i := 0; i < 20; i++ {
+		for chatID := int64(1); chatID <= 3; chatID++ {
+			if err := pool.Submit(context.Background(), message(chatID, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	pool.Close()
+
+	for chatID := int64(1); chatID <= 3; chatID++ {
+		got := handled[chatID]
+		if len(got) != 20 {
+			t.Fatalf("chat %d: handled %d updates, want 20", chatID, len(got))
+		}
+		for i, id := range got {
+			if id != i {
+				t.Fatalf("chat %d: updates handled in order %v", chatID, got)
+			}
+		}
+	}
+}
+
+func TestPoolRunsChatsConcurrently(t *testing.T) {
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	other := make(chan struct{})
+	pool := New(context.Background(), 2, 1, func(ctx context.Context, update tgbotapi.Update) {
+		switch ChatID(update) {
+		case 1:
+			close(blocked)
+			<-release
+		case 2:
+			close(other)
+		}
+	})
+	defer pool.Close()
+	defer close(release)
+
+	pool.Submit(context.Background(), message(1, 1))
+	wait(t, blocked, "chat 1")
+	// Chat 2 goes to the other worker and is not held up by chat 1
+	pool.Submit(context.Background(), message(2, 2))
+	wait(t, other, "chat 2")
+}
+
+func TestSubmitBlocksOnFullQueue(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	pool := New(context.Background(), 1, 1, func(ctx context.Context, update tgbotapi.Update) {
+		started <- struct{}{}
+		<-release
+	})
+	defer pool.Close()
+	defer close(release)
+
+	pool.Submit(context.Background(), message(1, 1))
+	wait(t, started, "the first update")
+	// Fills the queue while the worker is busy
+	if err := pool.Submit(context.Background(), message(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.Submit(ctx, message(1, 3))
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Submit returned %v with the queue full", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Submit = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Submit did not return after the context was cancelled")
+	}
+}
+
+func TestCloseDrainsQueue(t *testing.T) {
+	release := make(chan struct{})
+	var (
+		mu      sync.Mutex
+		handled []int
+	)
+	pool := New(context.Background(), 1, 5, func(ctx context.Context, update tgbotapi.Update) {
+		<-release
+		mu.Lock()
+		handled = append(handled, update.UpdateID)
+		mu.Unlock()
+	})
+
+	for i := 0; i < 5; i++ {
+		if err := pool.Submit(context.Background(), message(1, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		pool.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the queued updates were handled")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	wait(t, closed, "Close")
+	if len(handled) != 5 {
+		t.Errorf("handled %d updates before Close returned, want 5", len(handled))
+	}
+
+	if err := pool.Submit(context.Background(), message(1, 5)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit after Close = %v, want %v", err, ErrClosed)
+	}
+	// Closing twice is harmless
+	pool.Close()
+}
+
+func TestPoolRecoversFromPanic(t *testing.T) {
+	handled := make(chan int, 2)
+	pool := New(context.Background(), 1, 2, func(ctx context.Context, update tgbotapi.Update) {
+		if update.UpdateID == 1 {
+			panic("handler failed")
+		}
+		handled <- update.UpdateID
+	})
+	defer pool.Close()
+
+	pool.Submit(context.Background(), message(1, 1))
+	pool.Submit(context.Background(), message(1, 2))
+	select {
+	case id := <-handled:
+		if id != 2 {
+			t.Errorf("handled update %d, want 2", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("worker stopped after a handler panicked")
+	}
+}
+
+func TestChatID(t *testing.T) {
+	tests := []struct {
+		name   string
+		update tgbotapi.Update
+		want   int64
+	}{
+		{name: "message", update: message(-100, 1), want: -100},
+		{name: "callback", update: tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+			Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 5}}, From: &tgbotapi.User{ID: 6}}}, want: 5},
+		{name: "inline callback", update: tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: 6}}}, want: 6},
+		{name: "empty", update: tgbotapi.Update{}, want: 0},
+	}
+	for _, tt := range tests {
+		if got := ChatID(tt.update); got != tt.want {
+			t.Errorf("%s: ChatID = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"tgbot/logging"
+	"tgbot/messenger"
+	"tgbot/state"
+	"tgbot/storage"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// End is returned by Handle to finish the flow.
+const End = ""
+
+// Commands understood in every state.
+const (
+	CancelCommand = "/cancel"
+	BackCommand   = "/back"
+)
+
+// Context is what the functions of a state work with.
+type Context struct {
+	// Ctx is cancelled when handling the update must stop, e.g. on shutdown.
+	Ctx    context.Context
+	ChatID int64
+	// Msg is the message being handled. It is nil while a state is prompting
+	// after a button press.
+	Msg   *tgbotapi.Message
+	Repos *storage.Repos
+	Bot   messenger.Messenger
+	// Data is attached to the conversation and carried from state to state.
+	Data map[string]string
+	// Flow is the name of the flow the message was dispatched to, if any.
+	Flow string
+}
+
+func NewContext(ctx context.Context, chatID int64, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) *Context {
+	return &Context{
+		Ctx:    ctx,
+		ChatID: chatID,
+		Msg:    msg,
+		Repos:  repos,
+		Bot:    botInstance,
+		Data:   make(map[string]string),
+	}
+}
+
+// Text returns the text of the message being handled.
+func (c *Context) Text() string {
+	if c.Msg == nil {
+		return ""
+	}
+	return c.Msg.Text
+}
+
+// Int returns a number stored in Data, or 0 if it is missing.
+func (c *Context) Int(key string) int {
+	n, _ := strconv.Atoi(c.Data[key])
+	return n
+}
+
+// Reply sends a text message to the chat.
+func (c *Context) Reply(text string) {
+	c.Bot.Send(tgbotapi.NewMessage(c.ChatID, text))
+}
+
+// Ask returns a Prompt sending the text.
+func Ask(text string) func(c *Context) {
+	return func(c *Context) {
+		c.Reply(text)
+	}
+}
+
+// State is one step of a flow.
+type State struct {
+	Name string
+	// Prompt asks for the state's input whenever the flow enters the state.
+	Prompt func(c *Context)
+	// Validate checks the input before anything is changed. The error is
+	// shown to the user, who stays in the state and can try again.
+	Validate func(c *Context) error
+	// Handle acts on valid input and returns the next state, or End.
+	Handle func(c *Context) (string, error)
+	// Back is the state /back returns to. Empty means /back is not allowed.
+	Back string
+	// TTL is how long the chat stays in the state when the user stops
+	// answering. NewRouter requires it, so no state is kept for good.
+	TTL time.Duration
+}
+
+// Flow is a multi-step conversation such as registration or test upload.
+type Flow struct {
+	Name   string
+	States []State
+	// Permission is the admin permission needed to use the flow, empty for
+	// flows open to everyone. Router does not check it itself, see
+	// Router.Permission.
+	Permission string
+	// Cancel runs when the user abandons the flow with /cancel, after its
+	// state is removed. Without it the user gets a plain confirmation and any
+	// reply keyboard is removed.
+	Cancel func(c *Context)
+}
+
+// Failure is an error from Handle whose Message is shown to the user. Any
+// other error is shown as a generic message. In both cases the flow ends.
+type Failure struct {
+	Message string
+	Err     error
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("%s: %v", f.Message, f.Err)
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// Fail returns a Failure showing message to the user.
+func Fail(message string, err error) error {
+	return &Failure{Message: message, Err: err}
+}
+
+type route struct {
+	flow  *Flow
+	state *State
+}
+
+// Router sends messages of chats in a conversation to their current state.
+type Router struct {
+	routes map[string]route
+}
+
+// NewRouter indexes the states of the flows. State names must be unique
+// across all flows.
+func NewRouter(flows ...Flow) *Router {
+	r := &Router{routes: make(map[string]route)}
+	for i := range flows {
+		flow := &flows[i]
+		for j := range flow.States {
+			s := &flow.States[j]
+			if s.Handle == nil {
+				panic(fmt.Sprintf("fsm: state %s of flow %s has no handler", s.Name, flow.Name))
+			}
+			if s.TTL <= 0 {
+				panic(fmt.Sprintf("fsm: state %s of flow %s has no TTL", s.Name, flow.Name))
+			}
+			if other, ok := r.routes[s.Name]; ok {
+				panic(fmt.Sprintf("fsm: state %s is declared by flows %s and %s", s.Name, other.flow.Name, flow.Name))
+			}
+			r.routes[s.Name] = route{flow: flow, state: s}
+		}
+	}
+	for name, rt := range r.routes {
+		if back := rt.state.Back; back != "" && r.routes[back].flow != rt.flow {
+			panic(fmt.Sprintf("fsm: state %s goes back to %s, which is not in flow %s", name, back, rt.flow.Name))
+		}
+	}
+	return r
+}
+
+// Permission returns the permission needed for the flow of the named state,
+// and false if the router has no such state.
+func (r *Router) Permission(name string) (string, bool) {
+	rt, ok := r.routes[name]
+	if !ok {
+		return "", false
+	}
+	return rt.flow.Permission, true
+}
+
+// Start moves the chat to the named state, keeping c.Data, and prompts for
+// its input.
+func (r *Router) Start(c *Context, name string) {
+	if _, ok := r.routes[name]; !ok {
+		slog.WarnContext(c.Ctx, "Cannot start unknown state", "state", name)
+		return
+	}
+	r.enter(c, name)
+}
+
+// Dispatch handles the message if the chat is in one of the router's states
+// and reports whether it did. The state resolved for the update is used, so
+// the message is routed by the state it was authorized against.
+func (r *Router) Dispatch(c *Context) bool {
+	current := state.Current(c.Ctx, c.ChatID)
+	if current.Name == "" {
+		return false
+	}
+
+	rt, ok := r.routes[current.Name]
+	if !ok {
+		slog.WarnContext(c.Ctx, "Chat is in unknown state, resetting", "state", current.Name)
+		state.Delete(c.Ctx, c.ChatID)
+		return false
+	}
+
+	for k, v := range current.Data {
+		c.Data[k] = v
+	}
+	c.Flow = rt.flow.Name
+	c.Ctx = logging.With(c.Ctx, "handler", c.Flow, "state", current.Name)
+
+	switch command(c.Text()) {
+	case CancelCommand:
+		state.Delete(c.Ctx, c.ChatID)
+		rt.cancel(c)
+		return true
+	case BackCommand:
+		if rt.state.Back == "" {
+			c.Reply("Bu bosqichdan orqaga qaytib bo'lmaydi. Bekor qilish uchun /cancel yuboring.")
+			return true
+		}
+		r.enter(c, rt.state.Back)
+		return true
+	}
+
+	if rt.state.Validate != nil {
+		if err := rt.state.Validate(c); err != nil {
+			c.Reply(err.Error())
+			return true
+		}
+	}
+
+	next, err := rt.state.Handle(c)
+	if err != nil {
+		slog.ErrorContext(c.Ctx, "Error in state of flow", "state", rt.state.Name, "flow", rt.flow.Name, "err", err)
+		state.Delete(c.Ctx, c.ChatID)
+		var failure *Failure
+		if errors.As(err, &failure) {
+			c.Reply(failure.Message)
+		} else {
+			c.Reply("Xatolik yuz berdi. Iltimos, qaytadan urinib ko'ring.")
+		}
+		return true
+	}
+
+	r.enter(c, next)
+	return true
+}
+
+// Cancel abandons the chat's flow and reports whether there was one.
+func (r *Router) Cancel(c *Context) bool {
+	current := state.Get(c.Ctx, c.ChatID)
+	if current.Name == "" {
+		return false
+	}
+	state.Delete(c.Ctx, c.ChatID)
+
+	if rt, ok := r.routes[current.Name]; ok {
+		for k, v := range current.Data {
+			c.Data[k] = v
+		}
+		rt.cancel(c)
+	}
+	return true
+}
+
+func (rt route) cancel(c *Context) {
+	if rt.flow.Cancel != nil {
+		rt.flow.Cancel(c)
+		return
+	}
+	msgResponse := tgbotapi.NewMessage(c.ChatID, "Bekor qilindi.")
+	msgResponse.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	c.Bot.Send(msgResponse)
+}
+
+// command returns the command of the text without its arguments or the bot
+// username, as in "/cancel now" or "/cancel@my_bot".
+func command(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return ""
+	}
+	name, _, _ := strings.Cut(strings.Fields(text)[0], "@")
+	return name
+}
+
+func (r *Router) enter(c *Context, name string) {
+	if name == End {
+		state.Delete(c.Ctx, c.ChatID)
+		return
+	}
+
+	rt, ok := r.routes[name]
+	if !ok {
+		slog.WarnContext(c.Ctx, "Flow moved chat to unknown state", "state", name)
+		state.Delete(c.Ctx, c.ChatID)
+		return
+	}
+
+	state.Set(c.Ctx, c.ChatID, name, c.Data, rt.state.TTL)
+	if rt.state.Prompt != nil {
+		rt.state.Prompt(c)
+	}
+}
@@ -0,0 +1,266 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbot/state"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// bot records the texts of the messages sent.
+type bot struct {
+	texts []string
+}
+
+func (b *bot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if msg, ok := c.(tgbotapi.MessageConfig); ok {
+		b.texts = append(b.texts, msg.Text)
+	}
+	return tgbotapi.Message{}, nil
+}
+
+func (b *bot) Delete(chatID int64, messageID int) error { return nil }
+
+func (b *bot) AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error) {
+	return tgbotapi.APIResponse{}, nil
+}
+
+func (b *bot) GetFile(config tgbotapi.FileConfig) (tgbotapi.File, error) {
+	return tgbotapi.File{}, nil
+}
+
+func (b *bot) GetChat(config tgbotapi.ChatConfig) (tgbotapi.Chat, error) {
+	return tgbotapi.Chat{}, nil
+}
+
+func (b *bot) GetChatMember(config tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error) {
+	return tgbotapi.ChatMember{}, nil
+}
+
+func (b *bot) Download(ctx context.Context, fileID string) ([]byte, error) {
+	return nil, nil
+}
+
+func (b *bot) last() string {
+	if len(b.texts) == 0 {
+		return ""
+	}
+	return b.texts[len(b.texts)-1]
+}
+
+const chatID = 1
+
+// testRouter has a flow asking for a name and then an age. An age of 0 fails
+// with a message for the user, a negative one with any other error.
+func testRouter(handled *[]string) *Router {
+	return NewRouter(Flow{
+		Name: "profile",
+		States: []State{
+			{
+				Name:   "waiting_for_name",
+				TTL:    time.Hour,
+				Prompt: Ask("name?"),
+				Validate: func(c *Context) error {
+					if c.Text() == "" {
+						return errors.New("name is empty")
+					}
+					return nil
+				},
+				Handle: func(c *Context) (string, error) {
+					*handled = append(*handled, "name "+c.Text())
+					c.Data["name"] = c.Text()
+					return "waiting_for_age", nil
+				},
+			},
+			{
+				Name:   "waiting_for_age",
+				TTL:    time.Hour,
+				Prompt: Ask("age?"),
+				Handle: func(c *Context) (string, error) {
+					*handled = append(*handled, "age "+c.Text())
+					switch c.Text() {
+					case "0":
+						return End, Fail("age is zero", errors.New("zero age"))
+					case "-1":
+						return End, errors.New("negative age")
+					}
+					return End, nil
+				},
+				Back: "waiting_for_name",
+			},
+		},
+		Cancel: func(c *Context) {
+			c.Reply("cancelled " + c.Data["name"])
+		},
+	})
+}
+
+// send dispatches text as if the chat had sent it, and returns whether the
+// router handled it.
+func send(r *Router, b *bot, text string) bool {
+	ctx := state.Resolve(context.Background(), chatID)
+	msg := &tgbotapi.Message{Text: text, Chat: &tgbotapi.Chat{ID: chatID}}
+	return r.Dispatch(NewContext(ctx, chatID, msg, nil, b))
+}
+
+func setup(t *testing.T) (*Router, *bot, *[]string) {
+	t.Helper()
+	state.Use(state.NewMemoryStore())
+	t.Cleanup(func() { state.Use(state.NewMemoryStore()) })
+
+	var handled []string
+	r := testRouter(&handled)
+	b := &bot{}
+	r.Start(NewContext(context.Background(), chatID, nil, nil, b), "waiting_for_name")
+	return r, b, &handled
+}
+
+func current() string {
+	return state.Get(context.Background(), chatID).Name
+}
+
+func TestDispatch(t *testing.T) {
+	r, b, handled := setup(t)
+	if b.last() != "name?" || current() != "waiting_for_name" {
+		t.Fatalf("after Start: sent %q, state %q", b.texts, current())
+	}
+
+	// Invalid input is not handled and the chat stays in the state
+	if !send(r, b, "") {
+		t.Fatal("message in a flow was not dispatched")
+	}
+	if len(*handled) != 0 || b.last() != "name is empty" || current() != "waiting_for_name" {
+		t.Fatalf("after invalid input: handled %q, sent %q, state %q", *handled, b.last(), current())
+	}
+
+	send(r, b, "Vali")
+	if b.last() != "age?" || current() != "waiting_for_age" {
+		t.Fatalf("after name: sent %q, state %q", b.last(), current())
+	}
+	if data := state.Get(context.Background(), chatID).Data; data["name"] != "Vali" {
+		t.Errorf("data carried to the next state = %v", data)
+	}
+
+	send(r, b, "15")
+	if current() != "" {
+		t.Errorf("state after the flow ended = %q", current())
+	}
+	if want := []string{"name Vali", "age 15"}; strings.Join(*handled, ",") != strings.Join(want, ",") {
+		t.Errorf("handled %q, want %q", *handled, want)
+	}
+
+	if send(r, b, "hello") {
+		t.Error("message without a state was dispatched")
+	}
+}
+
+func TestDispatchFailure(t *testing.T) {
+	tests := []struct {
+		age  string
+		want string
+	}{
+		{age: "0", want: "age is zero"},
+		{age: "-1", want: "Xatolik yuz berdi."},
+	}
+	for _, tt := range tests {
+		r, b, _ := setup(t)
+		send(r, b, "Vali")
+		send(r, b, tt.age)
+		if !strings.HasPrefix(b.last(), tt.want) {
+			t.Errorf("age %s: sent %q, want %q", tt.age, b.last(), tt.want)
+		}
+		if current() != "" {
+			t.Errorf("age %s: flow did not end, state %q", tt.age, current())
+		}
+	}
+}
+
+func TestBack(t *testing.T) {
+	for _, text := range []string{"/back", "/back ", "/back please", "/back@my_bot"} {
+		r, b, handled := setup(t)
+		send(r, b, "Vali")
+		send(r, b, text)
+		if current() != "waiting_for_name" || b.last() != "name?" {
+			t.Errorf("%q: state %q, sent %q", text, current(), b.last())
+		}
+		if len(*handled) != 1 {
+			t.Errorf("%q was handled as input: %q", text, *handled)
+		}
+	}
+
+	// The first state has nowhere to go back to
+	r, b, _ := setup(t)
+	send(r, b, "/back")
+	if current() != "waiting_for_name" || !strings.Contains(b.last(), "orqaga qaytib bo'lmaydi") {
+		t.Errorf("/back from the first state: state %q, sent %q", current(), b.last())
+	}
+}
+
+func TestCancel(t *testing.T) {
+	for _, text := range []string{"/cancel", "/cancel now", " /cancel@my_bot "} {
+		r, b, handled := setup(t)
+		send(r, b, "Vali")
+		send(r, b, text)
+		if current() != "" {
+			t.Errorf("%q: state after cancel = %q", text, current())
+		}
+		if b.last() != "cancelled Vali" {
+			t.Errorf("%q: sent %q, want the flow's Cancel with its data", text, b.last())
+		}
+		if len(*handled) != 1 {
+			t.Errorf("%q was handled as input: %q", text, *handled)
+		}
+	}
+}
+
+func TestUnknownState(t *testing.T) {
+	r, b, _ := setup(t)
+	state.Set(context.Background(), chatID, "removed_state", nil, time.Hour)
+
+	if send(r, b, "Vali") {
+		t.Error("message in an unknown state was dispatched")
+	}
+	if current() != "" {
+		t.Errorf("unknown state was kept: %q", current())
+	}
+	if name, ok := r.Permission("removed_state"); ok {
+		t.Errorf("Permission of unknown state = %q, true", name)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := map[string]string{
+		"/cancel":          "/cancel",
+		" /cancel ":        "/cancel",
+		"/cancel now":      "/cancel",
+		"/back@my_bot":     "/back",
+		"/back@my_bot now": "/back",
+		"/":                "/",
+		"cancel":           "",
+		"":                 "",
+		"Vali /cancel":     "",
+	}
+	for text, want := range tests {
+		if got := command(text); got != want {
+			t.Errorf("command(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestNewRouterRequiresTTL(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "no TTL") {
+			t.Errorf("NewRouter panic = %v, want a missing TTL", r)
+		}
+	}()
+	NewRouter(Flow{Name: "flow", States: []State{{
+		Name:   "waiting",
+		Handle: func(c *Context) (string, error) { return End, nil },
+	}}})
+}
@@ -5,9 +5,8 @@ go 1.22.0
 require (
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
 	github.com/lib/pq v1.10.9
+	github.com/tealeg/xlsx v1.0.5
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/tealeg/xlsx v1.0.5 // indirect
-	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
-)
+require github.com/technoweenie/multipartstreamer v1.0.1 // indirect
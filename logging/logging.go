@@ -0,0 +1,118 @@
+// Package logging sets up the JSON logger and carries per-update fields,
+// such as the update and chat IDs, in contexts.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Redacted replaces the values of personal data in log records.
+const Redacted = "[REDACTED]"
+
+// PersonalKeys are the attribute keys whose values are never written.
+var PersonalKeys = map[string]bool{
+	"full_name":    true,
+	"first_name":   true,
+	"last_name":    true,
+	"username":     true,
+	"phone":        true,
+	"phone_number": true,
+}
+
+// Setup makes a JSON logger writing records at level and above to stderr the
+// default for slog and the log package.
+func Setup(level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	slog.SetDefault(slog.New(newHandler(os.Stderr, l)))
+	return nil
+}
+
+// newHandler writes JSON records at level and above to w, with personal data
+// redacted and the fields attached with With added.
+func newHandler(w io.Writer, level slog.Level) slog.Handler {
+	return contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	})}
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if PersonalKeys[a.Key] {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// Fatal logs the error and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type fieldsKey struct{}
+
+// With returns a context whose log records carry the key-value pairs in
+// addition to the fields already in ctx. A key given again replaces the
+// earlier value.
+func With(ctx context.Context, args ...any) context.Context {
+	var r slog.Record
+	r.Add(args...)
+	var added []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		added = append(added, a)
+		return true
+	})
+
+	var merged []slog.Attr
+	for _, a := range fields(ctx) {
+		if !hasKey(added, a.Key) {
+			merged = append(merged, a)
+		}
+	}
+	merged = append(merged, added...)
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+func fields(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	return attrs
+}
+
+func hasKey(attrs []slog.Attr, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// contextHandler adds the fields attached with With to every record logged
+// with a context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(fields(ctx)...)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
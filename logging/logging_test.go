@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// record logs one message with the handler of Setup and returns it decoded.
+func record(t *testing.T, log func(logger *slog.Logger)) map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	log(slog.New(newHandler(&buf, slog.LevelDebug)))
+
+	var fields map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("log output %q is not one JSON record: %v", buf.String(), err)
+	}
+	return fields
+}
+
+func TestRedact(t *testing.T) {
+	fields := record(t, func(logger *slog.Logger) {
+		logger.Info("Registered",
+			"full_name", "Vali Aliyev",
+			"first_name", "Vali",
+			"last_name", "Aliyev",
+			"username", "vali",
+			"phone", "+998901234567",
+			"phone_number", "+998901234567",
+			"user_id", 42,
+			slog.Group("contact", "phone_number", "+998901234567"),
+		)
+	})
+
+	for key := range PersonalKeys {
+		if fields[key] != Redacted {
+			t.Errorf("%s = %v, want %s", key, fields[key], Redacted)
+		}
+	}
+	if contact, _ := fields["contact"].(map[string]any); contact["phone_number"] != Redacted {
+		t.Errorf("grouped phone_number = %v, want %s", contact["phone_number"], Redacted)
+	}
+	if fields["user_id"] != float64(42) {
+		t.Errorf("user_id = %v, want it kept", fields["user_id"])
+	}
+}
+
+func TestWith(t *testing.T) {
+	ctx := With(context.Background(), "update_id", 5, "chat_id", int64(7), "handler", "message")
+	ctx = With(ctx, "handler", "registration", "state", "waiting_for_region", "username", "vali")
+
+	fields := record(t, func(logger *slog.Logger) {
+		logger.InfoContext(ctx, "Handled update", "err", "none")
+	})
+
+	want := map[string]any{
+		"msg":       "Handled update",
+		"update_id": float64(5),
+		"chat_id":   float64(7),
+		"handler":   "registration",
+		"state":     "waiting_for_region",
+		"username":  Redacted,
+		"err":       "none",
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("%s = %v, want %v", key, fields[key], value)
+		}
+	}
+
+	// Records logged without the context do not get its fields
+	plain := record(t, func(logger *slog.Logger) {
+		logger.Info("Started")
+	})
+	if _, ok := plain["update_id"]; ok {
+		t.Errorf("record without context has update_id: %v", plain)
+	}
+}
+
+func TestWithKeepsParentContext(t *testing.T) {
+	parent := With(context.Background(), "update_id", 5)
+	With(parent, "update_id", 6, "chat_id", 7)
+
+	fields := record(t, func(logger *slog.Logger) {
+		logger.InfoContext(parent, "Handled update")
+	})
+	if fields["update_id"] != float64(5) || fields["chat_id"] != nil {
+		t.Errorf("parent context changed by With: %v", fields)
+	}
+}
+
+func TestSetupRejectsUnknownLevel(t *testing.T) {
+	if err := Setup("loud"); err == nil || !strings.Contains(err.Error(), "loud") {
+		t.Errorf("Setup(loud) = %v, want an error", err)
+	}
+}
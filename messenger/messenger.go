@@ -0,0 +1,83 @@
+package messenger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Messenger is the part of the Telegram Bot API the handlers use. Handlers
+// get it instead of *tgbotapi.BotAPI, so they can run against a fake server.
+//
+// Only Download takes a context. The Bot API client cannot cancel a request,
+// so the other calls are bounded by nothing but its HTTP client timeout
+// (timeouts.telegram in the config): they may outlive the update deadline and
+// keep running after a stop signal until Telegram answers or that timeout
+// passes.
+type Messenger interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Delete(chatID int64, messageID int) error
+	AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error)
+	GetFile(config tgbotapi.FileConfig) (tgbotapi.File, error)
+	GetChat(config tgbotapi.ChatConfig) (tgbotapi.Chat, error)
+	GetChatMember(config tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error)
+	// Download returns the contents of the file sent to the bot.
+	Download(ctx context.Context, fileID string) ([]byte, error)
+}
+
+// Telegram is the Messenger backed by the Bot API. Requests go through the
+// HTTP client of the bot. File downloads use its transport too, but not its
+// timeout, which is meant for API calls and may be shorter than a download.
+type Telegram struct {
+	*tgbotapi.BotAPI
+	// DownloadTimeout bounds each file download.
+	DownloadTimeout time.Duration
+	downloads       *http.Client
+}
+
+func NewTelegram(botInstance *tgbotapi.BotAPI, downloadTimeout time.Duration) *Telegram {
+	return &Telegram{
+		BotAPI:          botInstance,
+		DownloadTimeout: downloadTimeout,
+		downloads:       &http.Client{Transport: botInstance.Client.Transport},
+	}
+}
+
+func (t *Telegram) Delete(chatID int64, messageID int) error {
+	_, err := t.DeleteMessage(tgbotapi.NewDeleteMessage(chatID, messageID))
+	return err
+}
+
+func (t *Telegram) Download(ctx context.Context, fileID string) ([]byte, error) {
+	fileURL, err := t.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file config: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.DownloadTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %v", err)
+	}
+	response, err := t.downloads.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading file: %s", response.Status)
+	}
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file data: %v", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,87 @@
+package messenger
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"tgbot/telegramtest"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func newTelegram(t *testing.T, downloadTimeout time.Duration) (*Telegram, *telegramtest.Server) {
+	t.Helper()
+	server := telegramtest.NewServer()
+	bot, err := server.Bot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.AddFile("file-1", "test.pdf", []byte("%PDF"))
+	return NewTelegram(bot, downloadTimeout), server
+}
+
+func TestDownload(t *testing.T) {
+	telegram, _ := newTelegram(t, time.Second)
+
+	data, err := telegram.Download(context.Background(), "file-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "%PDF" {
+		t.Errorf("Download = %q", data)
+	}
+
+	if _, err := telegram.Download(context.Background(), "missing"); err == nil {
+		t.Error("downloading an unknown file succeeded")
+	}
+}
+
+// deadlineTransport records the deadline of every file download.
+type deadlineTransport struct {
+	next      http.RoundTripper
+	deadlines *[]time.Time
+}
+
+func (d deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Path, "/file/") {
+		deadline, _ := req.Context().Deadline()
+		*d.deadlines = append(*d.deadlines, deadline)
+	}
+	return d.next.RoundTrip(req)
+}
+
+func TestDownloadIgnoresAPITimeout(t *testing.T) {
+	server := telegramtest.NewServer()
+	server.AddFile("file-1", "test.pdf", []byte("%PDF"))
+	var deadlines []time.Time
+	client := server.Client()
+	client.Transport = deadlineTransport{next: client.Transport, deadlines: &deadlines}
+	client.Timeout = time.Second
+	bot, err := tgbotapi.NewBotAPIWithClient(telegramtest.Token, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTelegram(bot, time.Hour).Download(context.Background(), "file-1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(deadlines) != 1 {
+		t.Fatalf("%d downloads, want 1", len(deadlines))
+	}
+	if left := time.Until(deadlines[0]); left < 59*time.Minute {
+		t.Errorf("download deadline in %v, want the download timeout of 1h", left)
+	}
+}
+
+func TestDownloadCancelled(t *testing.T) {
+	telegram, _ := newTelegram(t, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := telegram.Download(ctx, "file-1"); err == nil {
+		t.Error("download with a cancelled context succeeded")
+	}
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Path is where Serve exposes the metrics.
+const Path = "/metrics"
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := WriteText(r.Context(), &buf); err != nil {
+			slog.ErrorContext(r.Context(), "Error writing metrics", "err", err)
+			http.Error(w, "error writing metrics", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// Serve exposes the metrics at Path on addr until the context is cancelled.
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(Path, Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// TelegramTransport counts failed Bot API requests in TelegramErrors. The Bot
+// API answers errors with a 4xx or 5xx status, so the body is left unread.
+type TelegramTransport struct {
+	// Next makes the requests; nil means http.DefaultTransport.
+	Next http.RoundTripper
+}
+
+func (t TelegramTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		TelegramErrors.Inc(apiMethod(req.URL.Path))
+	}
+	return resp, err
+}
+
+// apiMethod names the Bot API method of a request path such as
+// /bot<token>/sendMessage, without the token. Downloads of files sent to the
+// bot go to /file/bot<token>/<file path>.
+func apiMethod(p string) string {
+	if strings.HasPrefix(p, "/file/") {
+		return "download"
+	}
+	return path.Base(p)
+}
@@ -0,0 +1,316 @@
+// Package metrics keeps counters, gauges and histograms in memory and serves
+// them in the Prometheus text format, so the bot can be scraped without any
+// client library or push gateway.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram upper bounds in seconds, from 5ms to 10s.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// The metrics of the bot. Label values are kept short and few: handlers are
+// named by their command, button or flow, never by the text users send.
+var (
+	Updates = NewCounter("tgbot_updates_total",
+		"Updates handled, by update type.", "type")
+	HandlerDuration = NewHistogram("tgbot_handler_duration_seconds",
+		"Time spent handling an update, by handler.", DefaultBuckets, "handler")
+	TelegramErrors = NewCounter("tgbot_telegram_api_errors_total",
+		"Failed Bot API requests, by method.", "method")
+	QueryDuration = NewHistogram("tgbot_db_query_duration_seconds",
+		"Time spent running database queries, by operation.", DefaultBuckets, "operation")
+	BroadcastRecipients = NewGauge("tgbot_broadcast_recipients",
+		"Recipients of the running or the last broadcast.")
+	BroadcastRemaining = NewGauge("tgbot_broadcast_remaining",
+		"Messages of running broadcasts not sent yet.")
+	BroadcastMessages = NewCounter("tgbot_broadcast_messages_total",
+		"Broadcast messages, by result: sent or failed.", "result")
+	Submissions = NewCounter("tgbot_submissions_total",
+		"Answer submissions saved, by test.", "test_id")
+)
+
+type collector interface {
+	write(ctx context.Context, w io.Writer) error
+}
+
+var (
+	mu         sync.Mutex
+	collectors []collector
+)
+
+func register(c collector) {
+	mu.Lock()
+	defer mu.Unlock()
+	collectors = append(collectors, c)
+}
+
+// WriteText writes every metric in the Prometheus text exposition format.
+func WriteText(ctx context.Context, w io.Writer) error {
+	mu.Lock()
+	all := append([]collector(nil), collectors...)
+	mu.Unlock()
+
+	for _, c := range all {
+		if err := c.write(ctx, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// desc is what every metric has: its name, help text and label names.
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) header(w io.Writer, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+	return err
+}
+
+// key joins label values into a map key. It panics on a wrong number of
+// values, as that is a mistake in the code rather than in the input.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// pairs formats the label set stored under key, with extra appended as is.
+func (d desc) pairs(key string, extra string) string {
+	var parts []string
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			parts = append(parts, d.labels[i]+`="`+escape(value)+`"`)
+		}
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return escaper.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a value that only goes up, one per set of label values.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, labels}, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter with the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the counter with the label values.
+func (c *Counter) Add(v float64, values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+func (c *Counter) write(ctx context.Context, w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.header(w, "counter"); err != nil {
+		return err
+	}
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		_, err := fmt.Fprintf(w, "%s 0\n", c.name)
+		return err
+	}
+	for _, key := range sortedKeys(c.values) {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, c.pairs(key, ""), formatFloat(c.values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gauge is a single value that goes up and down.
+type Gauge struct {
+	desc
+	mu    sync.Mutex
+	value float64
+}
+
+// NewGauge registers a gauge without labels.
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help}}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value = v
+}
+
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value += v
+}
+
+func (g *Gauge) write(ctx context.Context, w io.Writer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.header(w, "gauge"); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value))
+	return err
+}
+
+// GaugeFunc is a gauge with one label whose values are read on every scrape.
+type GaugeFunc struct {
+	desc
+	read func(ctx context.Context) (map[string]int, error)
+}
+
+// NewGaugeFunc registers a gauge reporting the counts returned by read, with
+// the map keys as the values of label.
+func NewGaugeFunc(name, help, label string, read func(ctx context.Context) (map[string]int, error)) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name, help, []string{label}}, read: read}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(ctx context.Context, w io.Writer) error {
+	counts, err := g.read(ctx)
+	if err != nil {
+		// The other metrics are still worth serving.
+		slog.ErrorContext(ctx, "Error reading metric", "metric", g.name, "err", err)
+		return nil
+	}
+
+	if err := g.header(w, "gauge"); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(counts) {
+		if _, err := fmt.Fprintf(w, "%s%s %d\n", g.name, g.pairs(key, ""), counts[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Histogram counts observations in buckets, one set per label values.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+type series struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given ascending bucket upper
+// bounds and label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name, help, labels}, buckets: buckets, series: make(map[string]*series)}
+	register(h)
+	return h
+}
+
+// Observe records v for the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &series{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(ctx context.Context, w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.header(w, "histogram"); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			le := `le="` + formatFloat(bound) + `"`
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.pairs(key, le), s.counts[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.pairs(key, `le="+Inf"`), s.count); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n",
+			h.name, h.pairs(key, ""), formatFloat(s.sum), h.name, h.pairs(key, ""), s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
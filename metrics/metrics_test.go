@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %s", resp.Status)
+	}
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func assertLines(t *testing.T, body string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("scrape is missing %q", line)
+		}
+	}
+}
+
+func TestScrape(t *testing.T) {
+	counter := NewCounter("test_requests_total", "Requests.", "code", "path")
+	counter.Inc("200", "/")
+	counter.Add(2, "500", `/a"b`)
+	NewCounter("test_plain_total", "Plain.")
+	histogram := NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "handler")
+	histogram.Observe(0.05, "start")
+	histogram.Observe(0.5, "start")
+	histogram.Observe(3, "start")
+	gauge := NewGauge("test_queue", "Queue.")
+	gauge.Set(4)
+	gauge.Add(-1)
+	NewGaugeFunc("test_states", "States.", "state", func(ctx context.Context) (map[string]int, error) {
+		return map[string]int{"b": 2, "a": 1}, nil
+	})
+	NewGaugeFunc("test_broken", "Broken.", "state", func(ctx context.Context) (map[string]int, error) {
+		return nil, errors.New("database is down")
+	})
+
+	body := scrape(t)
+	assertLines(t, body,
+		"# HELP test_requests_total Requests.",
+		"# TYPE test_requests_total counter",
+		`test_requests_total{code="200",path="/"} 1`,
+		`test_requests_total{code="500",path="/a\"b"} 2`,
+		"test_plain_total 0",
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{handler="start",le="0.1"} 1`,
+		`test_duration_seconds_bucket{handler="start",le="1"} 2`,
+		`test_duration_seconds_bucket{handler="start",le="+Inf"} 3`,
+		`test_duration_seconds_sum{handler="start"} 3.55`,
+		`test_duration_seconds_count{handler="start"} 3`,
+		"# TYPE test_queue gauge",
+		"test_queue 3",
+		`test_states{state="a"} 1`,
+		`test_states{state="b"} 2`,
+	)
+	if strings.Contains(body, "test_broken") {
+		t.Error("metric whose read failed was served")
+	}
+	if strings.Index(body, `state="a"`) > strings.Index(body, `state="b"`) {
+		t.Error("label values are not sorted")
+	}
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	counter := NewCounter("test_labels_total", "Labels.", "method")
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic")
+		}
+	}()
+	counter.Inc()
+}
+
+func TestTelegramTransportCountsFailures(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sendMessage") {
+			http.Error(w, `{"ok":false}`, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer api.Close()
+
+	client := &http.Client{Transport: TelegramTransport{}}
+	for _, path := range []string{"/bot123:secret/sendMessage", "/bot123:secret/getMe", "/file/bot123:secret/documents/a.pdf"} {
+		resp, err := client.Get(api.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	body := scrape(t)
+	assertLines(t, body, `tgbot_telegram_api_errors_total{method="sendMessage"} 1`)
+	if strings.Contains(body, `method="getMe"`) {
+		t.Error("successful request was counted")
+	}
+	if strings.Contains(body, "secret") {
+		t.Error("bot token leaked into a label")
+	}
+}
+
+func TestAPIMethod(t *testing.T) {
+	tests := map[string]string{
+		"/bot123:abc/sendMessage":       "sendMessage",
+		"/bot123:abc/getUpdates":        "getUpdates",
+		"/file/bot123:abc/photos/1.jpg": "download",
+	}
+	for path, want := range tests {
+		if got := apiMethod(path); got != want {
+			t.Errorf("apiMethod(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS admins;
+DROP TABLE IF EXISTS channels;
+DROP TABLE IF EXISTS users;
@@ -1,4 +1,3 @@
--- Create users table
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY NOT NULL,
     user_id BIGINT UNIQUE NOT NULL,
@@ -17,21 +16,6 @@ CREATE TABLE IF NOT EXISTS channels (
     name VARCHAR(50)
 );
 
-CREATE TABLE IF NOT EXISTS answers (
-    answers TEXT
-);
-
--- Create admins table
 CREATE TABLE IF NOT EXISTS admins (
     id BIGINT UNIQUE NOT NULL
 );
-
-CREATE TABLE IF NOT EXISTS files (
-    id SERIAL PRIMARY KEY,
-    file_id TEXT UNIQUE NOT NULL,
-    file_name TEXT,
-    mime_type TEXT,
-    file_data BYTEA,
-    created_at TIMESTAMP DEFAULT NOW()
-);
-
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS answers;
+DROP TABLE IF EXISTS tests;
@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS tests (
+    id SERIAL PRIMARY KEY,
+    title VARCHAR(100) NOT NULL,
+    subject VARCHAR(50),
+    created_by BIGINT,
+    status VARCHAR(10) DEFAULT 'draft',
+    max_attempts INT NOT NULL DEFAULT 1,
+    results_visibility VARCHAR(20) NOT NULL DEFAULT 'immediate',
+    results_published BOOLEAN NOT NULL DEFAULT FALSE,
+    opens_at TIMESTAMPTZ,
+    closes_at TIMESTAMPTZ,
+    duration_minutes INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS answers (
+    test_id INT UNIQUE REFERENCES tests(id) ON DELETE CASCADE,
+    answers TEXT
+);
+
+CREATE TABLE IF NOT EXISTS files (
+    id SERIAL PRIMARY KEY,
+    test_id INT UNIQUE REFERENCES tests(id) ON DELETE CASCADE,
+    file_id TEXT NOT NULL,
+    file_name TEXT,
+    mime_type TEXT,
+    file_data BYTEA,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Databases created before tests were introduced kept a single answer key and file
+ALTER TABLE answers ADD COLUMN IF NOT EXISTS test_id INT UNIQUE REFERENCES tests(id) ON DELETE CASCADE;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS test_id INT UNIQUE REFERENCES tests(id) ON DELETE CASCADE;
+ALTER TABLE files DROP CONSTRAINT IF EXISTS files_file_id_key;
+ALTER TABLE tests ADD COLUMN IF NOT EXISTS max_attempts INT NOT NULL DEFAULT 1;
+ALTER TABLE tests ADD COLUMN IF NOT EXISTS results_visibility VARCHAR(20) NOT NULL DEFAULT 'immediate';
+ALTER TABLE tests ADD COLUMN IF NOT EXISTS results_published BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE tests ADD COLUMN IF NOT EXISTS opens_at TIMESTAMPTZ;
+ALTER TABLE tests ADD COLUMN IF NOT EXISTS closes_at TIMESTAMPTZ;
+ALTER TABLE tests ADD COLUMN IF NOT EXISTS duration_minutes INT NOT NULL DEFAULT 0;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS submissions (
+    id SERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    test_id INT NOT NULL REFERENCES tests(id) ON DELETE CASCADE,
+    answers TEXT NOT NULL,
+    correct BOOLEAN[] NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    max_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    voided BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS voided BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE submissions ALTER COLUMN score TYPE DOUBLE PRECISION;
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS max_score DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS submissions_user_test_idx ON submissions (user_id, test_id);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS test_sessions (
+    user_id BIGINT NOT NULL,
+    test_id INT NOT NULL REFERENCES tests(id) ON DELETE CASCADE,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deadline TIMESTAMPTZ,
+    reminded BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (user_id, test_id)
+);
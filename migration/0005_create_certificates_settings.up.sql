@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS certificates (
+    code VARCHAR(20) PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    test_id INT NOT NULL REFERENCES tests(id) ON DELETE CASCADE,
+    full_name TEXT NOT NULL,
+    test_title TEXT NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    max_score DOUBLE PRECISION NOT NULL,
+    rank INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, test_id)
+);
+
+CREATE TABLE IF NOT EXISTS settings (
+    key VARCHAR(50) PRIMARY KEY,
+    value TEXT NOT NULL
+);
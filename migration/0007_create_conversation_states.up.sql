@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS conversation_states (
+    chat_id BIGINT PRIMARY KEY,
+    state VARCHAR(50) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
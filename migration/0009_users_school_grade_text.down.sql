@@ -0,0 +1,3 @@
+-- Values without digits are lost
+ALTER TABLE users ALTER COLUMN school TYPE INT USING NULLIF(regexp_replace(school, '[^0-9]', '', 'g'), '')::int;
+ALTER TABLE users ALTER COLUMN grade TYPE INT USING NULLIF(regexp_replace(grade, '[^0-9]', '', 'g'), '')::int;
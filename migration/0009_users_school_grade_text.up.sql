@@ -0,0 +1,3 @@
+-- Registration stores school and grade as typed, e.g. "5-maktab" or "9-A"
+ALTER TABLE users ALTER COLUMN school TYPE TEXT USING school::text;
+ALTER TABLE users ALTER COLUMN grade TYPE TEXT USING grade::text;
@@ -0,0 +1 @@
+ALTER TABLE users ALTER COLUMN rate TYPE INT USING ROUND(rate)::int;
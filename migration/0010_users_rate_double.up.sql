@@ -0,0 +1,2 @@
+-- Rates are sums of weighted scores, which need not be whole numbers
+ALTER TABLE users ALTER COLUMN rate TYPE DOUBLE PRECISION;
@@ -0,0 +1,241 @@
+package migration
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migration files are named NNNN_description.up.sql and
+// NNNN_description.down.sql and applied in the order of their numbers.
+//
+//go:embed *.sql
+var files embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and whether it has been applied.
+type Status struct {
+	Migration
+	Applied bool
+}
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`
+
+// All returns the embedded migrations ordered by version.
+func All() ([]Migration, error) {
+	return load(files)
+}
+
+// load reads the migrations in the root of fsys.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	scripts := map[string]string{}
+	for _, entry := range entries {
+		version, name, direction, err := parseName(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		// "1_x.up.sql" and "0001_x.up.sql" are the same script
+		script := fmt.Sprintf("%d.%s", version, direction)
+		if other, ok := scripts[script]; ok {
+			return nil, fmt.Errorf("migration %d has two %s scripts: %s and %s", version, direction, other, entry.Name())
+		}
+		scripts[script] = entry.Name()
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d is named both %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if _, ok := scripts[fmt.Sprintf("%d.up", m.Version)]; !ok {
+			return nil, fmt.Errorf("migration %d %s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// parseName splits "0001_create_users.up.sql" into 1, "create_users" and "up".
+func parseName(filename string) (version int, name, direction string, err error) {
+	base := strings.TrimSuffix(filename, ".sql")
+	switch {
+	case strings.HasSuffix(base, ".up"):
+		direction = "up"
+	case strings.HasSuffix(base, ".down"):
+		direction = "down"
+	default:
+		return 0, "", "", fmt.Errorf("migration file %s must end in .up.sql or .down.sql", filename)
+	}
+	base = strings.TrimSuffix(base, "."+direction)
+
+	number, name, ok := strings.Cut(base, "_")
+	if !ok {
+		return 0, "", "", fmt.Errorf("migration file %s must start with a version number and an underscore", filename)
+	}
+	version, err = strconv.Atoi(number)
+	if err != nil || version <= 0 {
+		return 0, "", "", fmt.Errorf("migration file %s has an invalid version %q", filename, number)
+	}
+	return version, name, direction, nil
+}
+
+// Up applies every migration that has not been applied yet and returns them.
+// Each migration runs in its own transaction, so a failing one leaves the
+// ones before it applied.
+func Up(db *sql.DB) ([]Migration, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createTable); err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %v", err)
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		ok, err := run(db, m, true)
+		if err != nil {
+			return applied, fmt.Errorf("error applying migration %d %s: %v", m.Version, m.Name, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// Down rolls back the last steps applied migrations and returns them.
+func Down(db *sql.DB, steps int) ([]Migration, error) {
+	statuses, err := List(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack []Migration
+	for i := len(statuses) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		m := statuses[i]
+		if !m.Applied {
+			continue
+		}
+		if m.Down == "" {
+			return rolledBack, fmt.Errorf("migration %d %s cannot be rolled back: it has no down script", m.Version, m.Name)
+		}
+		if _, err := run(db, m.Migration, false); err != nil {
+			return rolledBack, fmt.Errorf("error rolling back migration %d %s: %v", m.Version, m.Name, err)
+		}
+		rolledBack = append(rolledBack, m.Migration)
+	}
+	return rolledBack, nil
+}
+
+// List returns every embedded migration with whether it has been applied.
+func List(db *sql.DB) ([]Status, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createTable); err != nil {
+		return nil, fmt.Errorf("error creating schema_migrations: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = Status{Migration: m, Applied: applied[m.Version]}
+	}
+	return statuses, nil
+}
+
+// run applies or rolls back m unless another process already did. It reports
+// whether the script ran.
+func run(db *sql.DB, m Migration, up bool) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Bots started together must not apply the same migration twice
+	if _, err := tx.Exec(`LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, m.Version).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists == up {
+		return false, nil
+	}
+
+	if up {
+		if _, err := tx.Exec(m.Up); err != nil {
+			return false, err
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+	} else {
+		if _, err := tx.Exec(m.Down); err != nil {
+			return false, err
+		}
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,140 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		filename  string
+		version   int
+		name      string
+		direction string
+		wantErr   bool
+	}{
+		{filename: "0001_create_users.up.sql", version: 1, name: "create_users", direction: "up"},
+		{filename: "0012_add_roles.down.sql", version: 12, name: "add_roles", direction: "down"},
+		{filename: "7_x.up.sql", version: 7, name: "x", direction: "up"},
+		{filename: "0001_create_users.sql", wantErr: true},
+		{filename: "0001_create_users.up.txt", wantErr: true},
+		{filename: "create_users.up.sql", wantErr: true},
+		{filename: "0001.up.sql", wantErr: true},
+		{filename: "abcd_create_users.up.sql", wantErr: true},
+		{filename: "0000_create_users.up.sql", wantErr: true},
+		{filename: "-1_create_users.up.sql", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		version, name, direction, err := parseName(tt.filename)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseName(%q) = %d, %q, %q, want error", tt.filename, version, name, direction)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseName(%q): %v", tt.filename, err)
+			continue
+		}
+		if version != tt.version || name != tt.name || direction != tt.direction {
+			t.Errorf("parseName(%q) = %d, %q, %q, want %d, %q, %q",
+				tt.filename, version, name, direction, tt.version, tt.name, tt.direction)
+		}
+	}
+}
+
+func script(text string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(text)}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := load(fstest.MapFS{
+		"0010_add_rate.up.sql":       script("ALTER 10"),
+		"0002_create_tests.up.sql":   script("CREATE 2"),
+		"0002_create_tests.down.sql": script("DROP 2"),
+		"0001_create_users.up.sql":   script("CREATE 1"),
+		"0003_no_down.up.sql":        script("CREATE 3"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ordered by version number, not by file name
+	var versions []int
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	if len(versions) != 4 || versions[0] != 1 || versions[1] != 2 || versions[2] != 3 || versions[3] != 10 {
+		t.Fatalf("versions = %v, want [1 2 3 10]", versions)
+	}
+
+	second := migrations[1]
+	if second.Name != "create_tests" || second.Up != "CREATE 2" || second.Down != "DROP 2" {
+		t.Errorf("migration 2 = %+v", second)
+	}
+	if migrations[2].Down != "" {
+		t.Errorf("migration 3 has down script %q", migrations[2].Down)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"0001_create_users.up.sql": script("CREATE"),
+				"1_create_users.up.sql":    script("CREATE"),
+			},
+			want: "migration 1 has two up scripts",
+		},
+		{
+			name: "one version with two names",
+			files: fstest.MapFS{
+				"0001_create_users.up.sql":    script("CREATE"),
+				"0001_create_people.down.sql": script("DROP"),
+			},
+			want: `migration 1 is named both`,
+		},
+		{
+			name: "missing up script",
+			files: fstest.MapFS{
+				"0001_create_users.up.sql":   script("CREATE"),
+				"0002_create_tests.down.sql": script("DROP"),
+			},
+			want: "migration 2 create_tests has no up script",
+		},
+		{
+			name:  "bad file name",
+			files: fstest.MapFS{"create_users.sql": script("CREATE")},
+			want:  "must end in .up.sql or .down.sql",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := load(tt.files)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: load error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d %s is at position %d; versions must not have gaps", m.Version, m.Name, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d %s has no down script", m.Version, m.Name)
+		}
+	}
+}
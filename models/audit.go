@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// AuditEntry records one administrative action. OldValue and NewValue are
+// empty when the action has nothing to compare.
+type AuditEntry struct {
+	ID        int
+	ActorID   int64
+	Action    string
+	Target    string
+	OldValue  string
+	NewValue  string
+	CreatedAt time.Time
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type Certificate struct {
+	Code      string
+	UserID    int64
+	TestID    int
+	FullName  string
+	TestTitle string
+	Score     float64
+	MaxScore  float64
+	Rank      int
+	CreatedAt time.Time
+}
@@ -1,11 +1,12 @@
 package models
 
 type DB struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	Name     string
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
 }
 
 var BotToken string
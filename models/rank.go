@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+type RankEntry struct {
+	Rank        int
+	UserID      int64
+	FullName    string
+	Score       float64
+	MaxScore    float64
+	SubmittedAt time.Time
+}
+
+// RankFilter narrows a leaderboard down to users with matching registration data.
+// Empty fields do not filter.
+type RankFilter struct {
+	Region   string
+	District string
+	School   string
+	Grade    string
+}
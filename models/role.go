@@ -0,0 +1,57 @@
+package models
+
+const (
+	RoleOwner   = "owner"
+	RoleAdmin   = "admin"
+	RoleTeacher = "teacher"
+	RoleViewer  = "viewer"
+)
+
+// Roles lists every role, most powerful first.
+var Roles = []string{RoleOwner, RoleAdmin, RoleTeacher, RoleViewer}
+
+const (
+	PermissionManageAdmins   = "manage_admins"
+	PermissionManageChannels = "manage_channels"
+	// PermissionUploadTests lets the role create tests and open, close,
+	// publish and reset attempts of the tests it created.
+	PermissionUploadTests = "upload_tests"
+	// PermissionManageTests extends PermissionUploadTests to every test.
+	PermissionManageTests = "manage_tests"
+	// PermissionManageSettings lets the role change settings shared by all
+	// tests, such as the certificate template.
+	PermissionManageSettings = "manage_settings"
+	PermissionBroadcast      = "broadcast"
+	PermissionExportData     = "export_data"
+	PermissionViewStats      = "view_stats"
+	PermissionViewAudit      = "view_audit"
+)
+
+var rolePermissions = map[string][]string{
+	RoleOwner: {
+		PermissionManageAdmins, PermissionManageChannels, PermissionUploadTests, PermissionManageTests,
+		PermissionManageSettings, PermissionBroadcast, PermissionExportData, PermissionViewStats, PermissionViewAudit,
+	},
+	RoleAdmin: {
+		PermissionManageChannels, PermissionUploadTests, PermissionManageTests,
+		PermissionManageSettings, PermissionBroadcast, PermissionExportData, PermissionViewStats, PermissionViewAudit,
+	},
+	RoleTeacher: {PermissionUploadTests, PermissionViewStats},
+	RoleViewer:  {PermissionViewStats},
+}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleCan reports whether the role grants the permission.
+func RoleCan(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
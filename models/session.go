@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type TestSession struct {
+	UserID    int64
+	TestID    int
+	StartedAt time.Time
+	// Deadline is zero when the session has no time limit.
+	Deadline time.Time
+	Reminded bool
+}
+
+// Expired reports whether the user's time for the test has run out.
+func (s TestSession) Expired(now time.Time) bool {
+	return !s.Deadline.IsZero() && !now.Before(s.Deadline)
+}
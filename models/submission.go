@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+type Submission struct {
+	ID        int
+	UserID    int64
+	TestID    int
+	Answers   string
+	Correct   []bool
+	Score     float64
+	MaxScore  float64
+	CreatedAt time.Time
+}
+
+// Percent is the score as a percentage of the maximum score.
+func (s Submission) Percent() float64 {
+	if s.MaxScore == 0 {
+		return 0
+	}
+	return s.Score / s.MaxScore * 100
+}
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+const (
+	TestStatusDraft  = "draft"
+	TestStatusActive = "active"
+	TestStatusClosed = "closed"
+)
+
+const (
+	ResultsImmediate     = "immediate"
+	ResultsAfterDeadline = "after_deadline"
+	ResultsManual        = "manual"
+)
+
+type Test struct {
+	ID        int
+	Title     string
+	Subject   string
+	CreatedBy int64
+	Status    string
+	// MaxAttempts limits submissions per user; 0 means unlimited practice mode.
+	MaxAttempts       int
+	ResultsVisibility string
+	ResultsPublished  bool
+	// OpensAt and ClosesAt are zero when the test has no time window.
+	OpensAt  time.Time
+	ClosesAt time.Time
+	// DurationMinutes limits each user's session; 0 means no limit.
+	DurationMinutes int
+	CreatedAt       time.Time
+}
+
+// IsOpen reports whether the test accepts participants at the given time.
+func (t Test) IsOpen(now time.Time) bool {
+	if t.Status != TestStatusActive {
+		return false
+	}
+	if !t.OpensAt.IsZero() && now.Before(t.OpensAt) {
+		return false
+	}
+	if !t.ClosesAt.IsZero() && !now.Before(t.ClosesAt) {
+		return false
+	}
+	return true
+}
+
+// ResultsVisible reports whether participants may see per-question correctness.
+func (t Test) ResultsVisible() bool {
+	return t.ResultsVisibility == ResultsImmediate || t.ResultsPublished
+}
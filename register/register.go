@@ -1,110 +1,199 @@
 package register
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
+	"time"
 
-	"tgbot/stats"
-	"tgbot/storage"
+	"tgbot/fsm"
+	"tgbot/models"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
+// ttl keeps an unfinished registration long enough to survive restarts and
+// users coming back the next day.
+const ttl = 30 * 24 * time.Hour
+
+// Flow asks a new user for their personal details one question at a time.
+var Flow = fsm.Flow{
+	Name: "registration",
+	States: []fsm.State{
+		{
+			Name:     "waiting_for_full_name",
+			TTL:      ttl,
+			Prompt:   fsm.Ask("Iltimos, ism va familyangizni kiriting: \n\n Namuna: Baxtiyor Urolov"),
+			Validate: requireText,
+			Handle:   HandleFullName,
+		},
+		{
+			Name:     "waiting_for_region",
+			TTL:      ttl,
+			Prompt:   fsm.Ask("Iltimos, viloyatingizni kiriting:"),
+			Validate: requireText,
+			Handle:   HandleRegion,
+			Back:     "waiting_for_full_name",
+		},
+		{
+			Name:     "waiting_for_district",
+			TTL:      ttl,
+			Prompt:   fsm.Ask("Iltimos, tumaningizni kiriting:"),
+			Validate: requireText,
+			Handle:   HandleDistrict,
+			Back:     "waiting_for_region",
+		},
+		{
+			Name:     "waiting_for_school",
+			TTL:      ttl,
+			Prompt:   fsm.Ask("Iltimos, maktabingizni kiriting: \n\n Namuna: 68"),
+			Validate: requireText,
+			Handle:   HandleSchool,
+			Back:     "waiting_for_district",
+		},
+		{
+			Name:     "waiting_for_grade",
+			TTL:      ttl,
+			Prompt:   askGrade,
+			Validate: requireText,
+			Handle:   HandleGrade,
+			Back:     "waiting_for_school",
+		},
+		{
+			Name:     "waiting_for_phone",
+			TTL:      ttl,
+			Prompt:   askPhone,
+			Validate: requirePhone,
+			Handle:   HandlePhone,
+			Back:     "waiting_for_grade",
+		},
+	},
+	Cancel: func(c *fsm.Context) {
+		msgResponse := tgbotapi.NewMessage(c.ChatID, "Ro'yxatdan o'tish bekor qilindi. Davom ettirish uchun /start buyrug'ini yuboring.")
+		msgResponse.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+		c.Bot.Send(msgResponse)
+	},
+}
 
-func HandleFullName(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	text := msg.Text
-
-	fmt.Println("Ism: ", text)
-
-	err := storage.UpdateUserFullName(db, chatID, text)
-	if err != nil {
-		log.Printf("Error updating full name: %v", err)
-		return
+// Resume returns the state asking for the first detail the user has not given
+// yet, or fsm.End if the registration is complete. Registration saves every
+// answer as it goes, so a user who cancelled halfway picks up where they left
+// off.
+func Resume(user models.User) string {
+	switch {
+	case user.FullName == "":
+		return "waiting_for_full_name"
+	case user.Region == "":
+		return "waiting_for_region"
+	case user.District == "":
+		return "waiting_for_district"
+	case user.School == "":
+		return "waiting_for_school"
+	case user.Grade == "":
+		return "waiting_for_grade"
+	case user.Phone == "":
+		return "waiting_for_phone"
 	}
-	stats.UsStats[chatID] = "waiting_for_region"
-	msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, viloyatingizni kiriting:")
-	botInstance.Send(msgResponse)
+	return fsm.End
 }
 
-func HandleRegion(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	text := msg.Text
+func askGrade(c *fsm.Context) {
+	msgResponse := tgbotapi.NewMessage(c.ChatID, "Iltimos, sinfingizni kiriting:\n\n Namuna: 10")
+	// Coming back from the phone question with /back leaves its keyboard open
+	msgResponse.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	c.Bot.Send(msgResponse)
+}
 
-	storage.UpdateUserRegion(db, chatID, text)
-	stats.UsStats[chatID] = "waiting_for_district"
-	msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, tumaningizni kiriting:")
-	botInstance.Send(msgResponse)
+func askPhone(c *fsm.Context) {
+	// Create custom keyboard with the "Share Phone Number" button
+	sharePhoneButton := tgbotapi.NewKeyboardButtonContact("Telefon raqamni ulashish")
+	keyboard := tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(sharePhoneButton),
+	)
+	msgResponse := tgbotapi.NewMessage(c.ChatID, "Iltimos, telefon raqamingizni ulashing:")
+	msgResponse.ReplyMarkup = keyboard
+	c.Bot.Send(msgResponse)
 }
 
-func HandleDistrict(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	text := msg.Text
+func requireText(c *fsm.Context) error {
+	if strings.TrimSpace(c.Text()) == "" {
+		return errors.New("Iltimos, javobni matn ko'rinishida yuboring:")
+	}
+	return nil
+}
 
-	storage.UpdateUserDistrict(db, chatID, text)
-	stats.UsStats[chatID] = "waiting_for_school"
-	msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, maktabingizni kiriting: \n\n Namuna: 68")
-	botInstance.Send(msgResponse)
+func requirePhone(c *fsm.Context) error {
+	if c.Msg.Contact != nil {
+		return nil
+	}
+	return requireText(c)
 }
 
-func HandleSchool(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	text := msg.Text
+func HandleFullName(c *fsm.Context) (string, error) {
+	text := strings.TrimSpace(c.Text())
 
-	storage.UpdateUserSchool(db, chatID, text)
-	stats.UsStats[chatID] = "waiting_for_grade"
-	msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, sinfingizni kiriting:\n\n Namuna: 10")
-	botInstance.Send(msgResponse)
+	if err := c.Repos.Users.UpdateFullName(c.Ctx, c.ChatID, text); err != nil {
+		return fsm.End, fmt.Errorf("error updating full name: %v", err)
+	}
+	return "waiting_for_region", nil
 }
 
-func HandleGrade(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
-	text := msg.Text
+func HandleRegion(c *fsm.Context) (string, error) {
+	if err := c.Repos.Users.UpdateRegion(c.Ctx, c.ChatID, strings.TrimSpace(c.Text())); err != nil {
+		return fsm.End, fmt.Errorf("error updating region: %v", err)
+	}
+	return "waiting_for_district", nil
+}
 
-	storage.UpdateUserGrade(db, chatID, text)
-	stats.UsStats[chatID] = "waiting_for_phone"
+func HandleDistrict(c *fsm.Context) (string, error) {
+	if err := c.Repos.Users.UpdateDistrict(c.Ctx, c.ChatID, strings.TrimSpace(c.Text())); err != nil {
+		return fsm.End, fmt.Errorf("error updating district: %v", err)
+	}
+	return "waiting_for_school", nil
+}
 
-	// Create custom keyboard with the "Share Phone Number" button
-	sharePhoneButton := tgbotapi.NewKeyboardButtonContact("Telefon raqamni ulashish")
-	keyboard := tgbotapi.NewReplyKeyboard(
-		tgbotapi.NewKeyboardButtonRow(sharePhoneButton),
-	)
-	msgResponse := tgbotapi.NewMessage(chatID, "Iltimos, telefon raqamingizni ulashing:")
-	msgResponse.ReplyMarkup = keyboard
-	botInstance.Send(msgResponse)
+func HandleSchool(c *fsm.Context) (string, error) {
+	if err := c.Repos.Users.UpdateSchool(c.Ctx, c.ChatID, strings.TrimSpace(c.Text())); err != nil {
+		return fsm.End, fmt.Errorf("error updating school: %v", err)
+	}
+	return "waiting_for_grade", nil
 }
 
+func HandleGrade(c *fsm.Context) (string, error) {
+	if err := c.Repos.Users.UpdateGrade(c.Ctx, c.ChatID, strings.TrimSpace(c.Text())); err != nil {
+		return fsm.End, fmt.Errorf("error updating grade: %v", err)
+	}
+	return "waiting_for_phone", nil
+}
 
-func HandlePhone(msg *tgbotapi.Message, db *sql.DB, botInstance *tgbotapi.BotAPI) {
-	chatID := msg.Chat.ID
+func HandlePhone(c *fsm.Context) (string, error) {
 	var phoneNumber string
-
-	if msg.Contact != nil {
-		phoneNumber = msg.Contact.PhoneNumber
+	if c.Msg.Contact != nil {
+		phoneNumber = c.Msg.Contact.PhoneNumber
 	} else {
-		phoneNumber = msg.Text
+		phoneNumber = strings.TrimSpace(c.Text())
+	}
+
+	if err := c.Repos.Users.UpdatePhone(c.Ctx, c.ChatID, phoneNumber); err != nil {
+		return fsm.End, fmt.Errorf("error updating phone: %v", err)
 	}
 
-	storage.UpdateUserPhone(db, chatID, phoneNumber)
-	delete(stats.UsStats, chatID)
-	
 	// Remove the custom keyboard
 	removeKeyboard := tgbotapi.NewRemoveKeyboard(true)
-	msgResponse := tgbotapi.NewMessage(chatID, "Ro'yxatdan o'tish muvaffaqiyatli yakunlandi!")
+	msgResponse := tgbotapi.NewMessage(c.ChatID, "Ro'yxatdan o'tish muvaffaqiyatli yakunlandi!")
 	msgResponse.ReplyMarkup = removeKeyboard
 
 	// Send the message about successful registration and remove the keyboard
-	botInstance.Send(msgResponse)
-	
+	c.Bot.Send(msgResponse)
+
 	// Now send the message with the inline button to start the test
 	startTestButton := tgbotapi.NewInlineKeyboardButtonData("Testni boshlash", "start_test")
 	inlineKeyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(startTestButton),
 	)
-	testMsg := tgbotapi.NewMessage(chatID, "Testni boshlash uchun quyidagi tugmani bosing.")
+	testMsg := tgbotapi.NewMessage(c.ChatID, "Testni boshlash uchun quyidagi tugmani bosing.")
 	testMsg.ReplyMarkup = inlineKeyboard
-	botInstance.Send(testMsg)
-}
-
+	c.Bot.Send(testMsg)
 
+	return fsm.End, nil
+}
@@ -0,0 +1,204 @@
+package results
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"tgbot/messenger"
+	"tgbot/models"
+	"tgbot/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	DefaultTopSize = 10
+	maxTopSize     = 50
+)
+
+var topOption = regexp.MustCompile(`(\w+)=("[^"]*"|\S+)`)
+
+// HandleTopCommand handles "/top [test_id] [viloyat=..] [tuman=..] [maktab=..] [sinf=..] [n=..]".
+// Without a test ID it shows the overall ranking.
+func HandleTopCommand(ctx context.Context, msg *tgbotapi.Message, repos *storage.Repos, botInstance messenger.Messenger) {
+	chatID := msg.Chat.ID
+	args := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/top"))
+
+	testID := 0
+	if fields := strings.Fields(args); len(fields) > 0 && !strings.Contains(fields[0], "=") {
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			msgResponse := tgbotapi.NewMessage(chatID, "Noto'g'ri test ID formati. \n\n Namuna: /top 4 viloyat=Samarqand sinf=10")
+			botInstance.Send(msgResponse)
+			return
+		}
+		testID = id
+		args = strings.TrimSpace(strings.TrimPrefix(args, fields[0]))
+	}
+
+	filter, limit, err := parseTopOptions(args)
+	if err != nil {
+		msgResponse := tgbotapi.NewMessage(chatID, fmt.Sprintf("%v \n\n Namuna: /top 4 viloyat=Samarqand sinf=10", err))
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	if testID == 0 {
+		ShowOverallLeaderboard(ctx, chatID, filter, limit, repos, botInstance)
+		return
+	}
+	ShowTestLeaderboard(ctx, chatID, testID, filter, limit, repos, botInstance)
+}
+
+func parseTopOptions(args string) (models.RankFilter, int, error) {
+	var filter models.RankFilter
+	limit := DefaultTopSize
+
+	last := 0
+	for _, m := range topOption.FindAllStringSubmatchIndex(args, -1) {
+		if rest := strings.TrimSpace(args[last:m[0]]); rest != "" {
+			return filter, limit, fmt.Errorf("tushunarsiz parametr %q.", rest)
+		}
+		last = m[1]
+
+		key := strings.ToLower(args[m[2]:m[3]])
+		value := strings.Trim(args[m[4]:m[5]], `"`)
+		switch key {
+		case "viloyat", "region":
+			filter.Region = value
+		case "tuman", "district":
+			filter.District = value
+		case "maktab", "school":
+			filter.School = value
+		case "sinf", "grade":
+			filter.Grade = value
+		case "n":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxTopSize {
+				return filter, limit, fmt.Errorf("n 1 dan %d gacha bo'lishi kerak.", maxTopSize)
+			}
+			limit = n
+		default:
+			return filter, limit, fmt.Errorf("noma'lum parametr %q.", key)
+		}
+	}
+	if rest := strings.TrimSpace(args[last:]); rest != "" {
+		return filter, limit, fmt.Errorf("tushunarsiz parametr %q.", rest)
+	}
+
+	return filter, limit, nil
+}
+
+func ShowTestLeaderboard(ctx context.Context, chatID int64, testID int, filter models.RankFilter, limit int, repos *storage.Repos, botInstance messenger.Messenger) {
+	test, err := repos.Tests.Get(ctx, testID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting test from database", "test_id", testID, "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Bunday test topilmadi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	// Scores of hidden tests are only visible to admins
+	if !test.ResultsVisible() && !repos.Admins.IsAdmin(ctx, chatID) {
+		msgResponse := tgbotapi.NewMessage(chatID, "Bu test natijalari hali e'lon qilinmagan.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	entries, err := repos.Submissions.Leaderboard(ctx, testID, filter, limit, chatID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting leaderboard of test", "test_id", testID, "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Reytingni olishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	title := fmt.Sprintf("%q testi bo'yicha reyting", test.Title)
+	msgResponse := tgbotapi.NewMessage(chatID, formatLeaderboard(title, filter, entries, limit, chatID, true))
+	botInstance.Send(msgResponse)
+}
+
+func ShowOverallLeaderboard(ctx context.Context, chatID int64, filter models.RankFilter, limit int, repos *storage.Repos, botInstance messenger.Messenger) {
+	entries, err := repos.Users.Leaderboard(ctx, filter, limit, chatID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting overall leaderboard", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Reytingni olishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	msgResponse := tgbotapi.NewMessage(chatID, formatLeaderboard("Umumiy reyting", filter, entries, limit, chatID, false))
+	botInstance.Send(msgResponse)
+}
+
+func formatLeaderboard(title string, filter models.RankFilter, entries []models.RankEntry, limit int, userID int64, withMax bool) string {
+	var b strings.Builder
+	b.WriteString(title)
+	for _, part := range []struct{ name, value string }{
+		{"viloyat", filter.Region}, {"tuman", filter.District}, {"maktab", filter.School}, {"sinf", filter.Grade},
+	} {
+		if part.value != "" {
+			fmt.Fprintf(&b, ", %s: %s", part.name, part.value)
+		}
+	}
+	b.WriteString("\n\n")
+
+	if len(entries) == 0 {
+		b.WriteString("Hozircha natijalar yo'q.")
+		return b.String()
+	}
+
+	var own *models.RankEntry
+	for i, entry := range entries {
+		if entry.UserID == userID {
+			own = &entries[i]
+		}
+		if entry.Rank > limit {
+			continue
+		}
+
+		name := entry.FullName
+		if name == "" {
+			name = fmt.Sprintf("ID %d", entry.UserID)
+		}
+		if withMax {
+			fmt.Fprintf(&b, "%d. %s — %.2f / %.2f\n", entry.Rank, name, entry.Score, entry.MaxScore)
+		} else {
+			fmt.Fprintf(&b, "%d. %s — %.2f\n", entry.Rank, name, entry.Score)
+		}
+	}
+
+	if own != nil {
+		fmt.Fprintf(&b, "\nSizning o'rningiz: %d", own.Rank)
+	}
+	return b.String()
+}
+
+// DisplayLeaderboardTests lets the caller pick which leaderboard to show.
+func DisplayLeaderboardTests(ctx context.Context, chatID int64, repos *storage.Repos, botInstance messenger.Messenger) {
+	tests, err := repos.Tests.All(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting tests from database", "err", err)
+		msgResponse := tgbotapi.NewMessage(chatID, "Testlarni olishda xatolik yuz berdi.")
+		botInstance.Send(msgResponse)
+		return
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Umumiy reyting", "top_all")),
+	}
+	for _, test := range tests {
+		if test.Status == models.TestStatusDraft {
+			continue
+		}
+		button := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("#%d %s", test.ID, test.Title), fmt.Sprintf("top_%d", test.ID))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+	}
+
+	msgResponse := tgbotapi.NewMessage(chatID, "Qaysi reytingni ko'rmoqchisiz? \n\n Filtrlash uchun: /top 4 viloyat=Samarqand tuman=Urgut maktab=12 sinf=10 n=20")
+	msgResponse.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	botInstance.Send(msgResponse)
+}
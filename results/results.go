@@ -0,0 +1,94 @@
+package results
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"tgbot/certificate"
+	"tgbot/config"
+	"tgbot/messenger"
+	"tgbot/models"
+	"tgbot/storage"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Breakdown formats a graded submission together with the numbers of the wrong answers.
+func Breakdown(test models.Test, submission models.Submission) string {
+	correctCount := 0
+	var incorrect []string
+	for i, ok := range submission.Correct {
+		if ok {
+			correctCount++
+		} else {
+			incorrect = append(incorrect, fmt.Sprint(i+1)) // Indices are 1-based for user readability
+		}
+	}
+
+	text := fmt.Sprintf("%q testi natijasi.\nTo'g'ri javoblar soni: %d/%d\nBall: %.2f / %.2f (%.1f%%)",
+		test.Title, correctCount, len(submission.Correct), submission.Score, submission.MaxScore, submission.Percent())
+	if len(incorrect) > 0 {
+		text += fmt.Sprintf("\nNoto'g'ri javoblar: %s", strings.Join(incorrect, ", "))
+	}
+	return text
+}
+
+// Pending is sent instead of the breakdown while results of the test are hidden.
+func Pending(test models.Test) string {
+	switch test.ResultsVisibility {
+	case models.ResultsAfterDeadline:
+		return "Javoblaringiz qabul qilindi. Natijalar test yopilgandan keyin yuboriladi."
+	default:
+		return "Javoblaringiz qabul qilindi. Natijalar admin e'lon qilganidan keyin yuboriladi."
+	}
+}
+
+// Publish marks the results of the test as published and sends every participant
+// the breakdown of their best submission. It returns the number of participants.
+func Publish(ctx context.Context, testID int, repos *storage.Repos, botInstance messenger.Messenger) (int, error) {
+	test, err := repos.Tests.Get(ctx, testID)
+	if err != nil {
+		return 0, fmt.Errorf("error getting test: %v", err)
+	}
+
+	if err := repos.Tests.MarkResultsPublished(ctx, testID); err != nil {
+		return 0, fmt.Errorf("error publishing results: %v", err)
+	}
+	test.ResultsPublished = true
+
+	submissions, err := repos.Submissions.Best(ctx, testID)
+	if err != nil {
+		return 0, fmt.Errorf("error getting submissions: %v", err)
+	}
+
+	// Scores of the test count towards the overall rating from now on
+	for _, submission := range submissions {
+		if err := repos.Users.UpdateRate(ctx, submission.UserID); err != nil {
+			slog.ErrorContext(ctx, "Error updating user rate", "user_id", submission.UserID, "err", err)
+		}
+	}
+
+	go sendResults(context.WithoutCancel(ctx), test, submissions, botInstance)
+	return len(submissions), nil
+}
+
+func sendResults(ctx context.Context, test models.Test, submissions []models.Submission, botInstance messenger.Messenger) {
+	ticker := time.NewTicker(config.Get().BroadcastInterval())
+	defer ticker.Stop()
+
+	count := 0
+	for _, submission := range submissions {
+		<-ticker.C
+		msg := tgbotapi.NewMessage(submission.UserID, Breakdown(test, submission))
+		msg.ReplyMarkup = certificate.Button(test.ID)
+		if _, err := botInstance.Send(msg); err != nil {
+			slog.ErrorContext(ctx, "Error sending results to user", "user_id", submission.UserID, "err", err)
+			continue
+		}
+		count++
+	}
+
+	slog.InfoContext(ctx, "Results of test sent to users", "test_id", test.ID, "count", count)
+}
@@ -0,0 +1,77 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"tgbot/messenger"
+	"tgbot/models"
+	"tgbot/results"
+	"tgbot/storage"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// ReminderBefore is how long before a user's deadline the reminder is sent.
+const ReminderBefore = 5 * time.Minute
+
+// Run closes tests whose time window has ended and reminds users whose
+// session is about to expire, until the context is cancelled.
+func Run(ctx context.Context, repos *storage.Repos, botInstance messenger.Messenger) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			closeExpiredTests(ctx, repos, botInstance)
+			sendReminders(ctx, repos, botInstance)
+		}
+	}
+}
+
+func closeExpiredTests(ctx context.Context, repos *storage.Repos, botInstance messenger.Messenger) {
+	tests, err := repos.Tests.Expired(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting expired tests", "err", err)
+		return
+	}
+
+	for _, test := range tests {
+		if err := repos.Tests.UpdateStatus(ctx, test.ID, models.TestStatusClosed); err != nil {
+			slog.ErrorContext(ctx, "Error closing test", "test_id", test.ID, "err", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Test closed at its deadline", "test_id", test.ID)
+
+		if test.ResultsVisibility == models.ResultsAfterDeadline && !test.ResultsPublished {
+			if _, err := results.Publish(ctx, test.ID, repos, botInstance); err != nil {
+				slog.ErrorContext(ctx, "Error publishing results of test", "test_id", test.ID, "err", err)
+			}
+		}
+	}
+}
+
+func sendReminders(ctx context.Context, repos *storage.Repos, botInstance messenger.Messenger) {
+	sessions, err := repos.Sessions.ToRemind(ctx, time.Now().Add(ReminderBefore))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting sessions to remind", "err", err)
+		return
+	}
+
+	for _, session := range sessions {
+		minutes := int(time.Until(session.Deadline).Minutes()) + 1
+		msg := tgbotapi.NewMessage(session.UserID, fmt.Sprintf("Test vaqti tugashiga %d daqiqa qoldi. Javoblaringizni yuborishni unutmang!", minutes))
+		if _, err := botInstance.Send(msg); err != nil {
+			slog.ErrorContext(ctx, "Error sending reminder to user", "user_id", session.UserID, "err", err)
+			continue
+		}
+
+		if err := repos.Sessions.MarkReminded(ctx, session.UserID, session.TestID); err != nil {
+			slog.ErrorContext(ctx, "Error marking session reminded", "err", err)
+		}
+	}
+}
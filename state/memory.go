@@ -0,0 +1,73 @@
+package state
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps states in memory. They are lost when the bot restarts.
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[int64]State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: make(map[int64]State)}
+}
+
+func (m *MemoryStore) Get(ctx context.Context, chatID int64) (State, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.states[chatID]
+	if !ok {
+		return State{}, false, nil
+	}
+	if s.Expired(time.Now()) {
+		delete(m.states, chatID)
+		return State{}, false, nil
+	}
+	return copyState(s), true, nil
+}
+
+func (m *MemoryStore) Set(ctx context.Context, chatID int64, s State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[chatID] = copyState(s)
+	return nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, chatID)
+	return nil
+}
+
+func (m *MemoryStore) Counts(ctx context.Context) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	counts := make(map[string]int)
+	for _, s := range m.states {
+		if !s.Expired(now) {
+			counts[s.Name]++
+		}
+	}
+	return counts, nil
+}
+
+// copyState keeps callers from changing a stored state's data without Set.
+func copyState(s State) State {
+	if s.Data == nil {
+		return s
+	}
+	data := make(map[string]string, len(s.Data))
+	for k, v := range s.Data {
+		data[k] = v
+	}
+	s.Data = data
+	return s
+}
@@ -0,0 +1,90 @@
+package state
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"tgbot/storage"
+)
+
+// PostgresStore keeps states in the conversation_states table so users can
+// continue where they left off after a restart. Expired rows are ignored and
+// overwritten by the chat's next state. Queries go through storage.DB, so they
+// have the same deadline and metrics as the repositories.
+type PostgresStore struct {
+	db storage.DB
+}
+
+func NewPostgresStore(db storage.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (p *PostgresStore) Get(ctx context.Context, chatID int64) (State, bool, error) {
+	var s State
+	var data []byte
+	var expiresAt sql.NullTime
+	err := p.db.QueryRowContext(ctx, `
+		SELECT state, data, expires_at
+		FROM conversation_states
+		WHERE chat_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	`, chatID).Scan(&s.Name, &data, &expiresAt)
+	if err == sql.ErrNoRows {
+		return State{}, false, nil
+	}
+	if err != nil {
+		return State{}, false, err
+	}
+
+	if err := json.Unmarshal(data, &s.Data); err != nil {
+		return State{}, false, err
+	}
+	if expiresAt.Valid {
+		s.ExpiresAt = expiresAt.Time
+	}
+	return s, true, nil
+}
+
+func (p *PostgresStore) Set(ctx context.Context, chatID int64, s State) error {
+	data, err := json.Marshal(s.Data)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := sql.NullTime{Time: s.ExpiresAt, Valid: !s.ExpiresAt.IsZero()}
+	_, err = p.db.ExecContext(ctx, `
+		INSERT INTO conversation_states (chat_id, state, data, expires_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (chat_id) DO UPDATE
+		SET state = EXCLUDED.state, data = EXCLUDED.data, expires_at = EXCLUDED.expires_at, updated_at = NOW()
+	`, chatID, s.Name, string(data), expiresAt)
+	return err
+}
+
+func (p *PostgresStore) Delete(ctx context.Context, chatID int64) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM conversation_states WHERE chat_id = $1", chatID)
+	return err
+}
+
+func (p *PostgresStore) Counts(ctx context.Context) (map[string]int, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT state, COUNT(*)
+		FROM conversation_states
+		WHERE expires_at IS NULL OR expires_at > NOW()
+		GROUP BY state
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		counts[name] = count
+	}
+	return counts, rows.Err()
+}
@@ -1,4 +1,106 @@
 package state
 
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+)
 
-var UserStates = make(map[int64]string)
+// State is the step of a multi-step conversation a chat is in, together with
+// the data collected so far.
+type State struct {
+	Name string
+	Data map[string]string
+	// ExpiresAt is when the state is forgotten. Zero means never.
+	ExpiresAt time.Time
+}
+
+// Int returns the data value as a number, or 0 if it is missing or invalid.
+func (s State) Int(key string) int {
+	n, _ := strconv.Atoi(s.Data[key])
+	return n
+}
+
+// Expired reports whether the state should no longer be used.
+func (s State) Expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+// StateStore keeps the conversation state of every chat. Get returns false
+// for chats without a state or whose state has expired.
+type StateStore interface {
+	Get(ctx context.Context, chatID int64) (State, bool, error)
+	Set(ctx context.Context, chatID int64, s State) error
+	Delete(ctx context.Context, chatID int64) error
+	// Counts returns the number of chats in each state, expired ones left out.
+	Counts(ctx context.Context) (map[string]int, error)
+}
+
+var store StateStore = NewMemoryStore()
+
+// Use replaces the store used by Get, Set, Delete and Counts.
+func Use(s StateStore) {
+	store = s
+}
+
+// Get returns the chat's current state. Chats without a state get the zero
+// State, whose Name is empty.
+func Get(ctx context.Context, chatID int64) State {
+	s, ok, err := store.Get(ctx, chatID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting state", "chat_id", chatID, "err", err)
+		return State{}
+	}
+	if !ok {
+		return State{}
+	}
+	return s
+}
+
+type resolvedKey struct{}
+
+type resolved struct {
+	chatID int64
+	state  State
+}
+
+// Resolve reads the chat's state once for the update being handled and
+// returns a context carrying it. Current returns the carried state, so the
+// guard and the router see the same state even if it expires in between.
+func Resolve(ctx context.Context, chatID int64) context.Context {
+	return context.WithValue(ctx, resolvedKey{}, resolved{chatID: chatID, state: Get(ctx, chatID)})
+}
+
+// Current returns the state resolved for the chat in ctx, or the chat's
+// current state if none was resolved.
+func Current(ctx context.Context, chatID int64) State {
+	if r, ok := ctx.Value(resolvedKey{}).(resolved); ok && r.chatID == chatID {
+		return r.state
+	}
+	return Get(ctx, chatID)
+}
+
+// Set moves the chat to the named state with the given data, which may be nil.
+// The state is forgotten after ttl, or kept until it is changed if ttl is 0.
+func Set(ctx context.Context, chatID int64, name string, data map[string]string, ttl time.Duration) {
+	s := State{Name: name, Data: data}
+	if ttl > 0 {
+		s.ExpiresAt = time.Now().Add(ttl)
+	}
+	if err := store.Set(ctx, chatID, s); err != nil {
+		slog.ErrorContext(ctx, "Error setting state", "chat_id", chatID, "state", name, "err", err)
+	}
+}
+
+// Delete ends the chat's conversation.
+func Delete(ctx context.Context, chatID int64) {
+	if err := store.Delete(ctx, chatID); err != nil {
+		slog.ErrorContext(ctx, "Error deleting state", "chat_id", chatID, "err", err)
+	}
+}
+
+// Counts returns the number of chats in each state.
+func Counts(ctx context.Context) (map[string]int, error) {
+	return store.Counts(ctx)
+}
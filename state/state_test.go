@@ -0,0 +1,113 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	if _, ok, err := store.Get(ctx, 1); ok || err != nil {
+		t.Fatalf("Get of a chat without state = %v, %v", ok, err)
+	}
+
+	data := map[string]string{"test_id": "4"}
+	store.Set(ctx, 1, State{Name: "waiting_for_answers", Data: data})
+	store.Set(ctx, 2, State{Name: "waiting_for_answers"})
+	store.Set(ctx, 3, State{Name: "waiting_for_full_name", ExpiresAt: time.Now().Add(time.Hour)})
+
+	// The store keeps its own copy of the data
+	data["test_id"] = "5"
+	got, ok, err := store.Get(ctx, 1)
+	if !ok || err != nil {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+	if got.Name != "waiting_for_answers" || got.Int("test_id") != 4 {
+		t.Fatalf("Get = %+v", got)
+	}
+	got.Data["test_id"] = "6"
+	if again, _, _ := store.Get(ctx, 1); again.Int("test_id") != 4 {
+		t.Errorf("changing the returned data changed the stored state: %+v", again)
+	}
+
+	counts, err := store.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["waiting_for_answers"] != 2 || counts["waiting_for_full_name"] != 1 {
+		t.Errorf("Counts = %v", counts)
+	}
+
+	store.Delete(ctx, 1)
+	if _, ok, _ := store.Get(ctx, 1); ok {
+		t.Error("state is still there after Delete")
+	}
+	// Deleting a chat without state is not an error
+	if err := store.Delete(ctx, 1); err != nil {
+		t.Errorf("Delete = %v", err)
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.Set(ctx, 1, State{Name: "waiting_for_admin_id", ExpiresAt: time.Now().Add(-time.Second)})
+	store.Set(ctx, 2, State{Name: "waiting_for_admin_id", ExpiresAt: time.Now().Add(time.Minute)})
+
+	counts, _ := store.Counts(ctx)
+	if counts["waiting_for_admin_id"] != 1 {
+		t.Errorf("Counts = %v, want the expired state left out", counts)
+	}
+	if _, ok, _ := store.Get(ctx, 1); ok {
+		t.Error("Get returned an expired state")
+	}
+	if _, ok, _ := store.Get(ctx, 2); !ok {
+		t.Error("Get did not return a state that has not expired")
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	ctx := context.Background()
+	Use(NewMemoryStore())
+	t.Cleanup(func() { Use(NewMemoryStore()) })
+
+	before := time.Now()
+	Set(ctx, 1, "waiting_for_answers", nil, time.Hour)
+	s := Get(ctx, 1)
+	if s.ExpiresAt.Before(before.Add(time.Hour)) || s.ExpiresAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("ExpiresAt = %v, want an hour from now", s.ExpiresAt)
+	}
+	if s.Expired(before.Add(59*time.Minute)) || !s.Expired(before.Add(61*time.Minute)) {
+		t.Errorf("state with ExpiresAt %v expires at the wrong time", s.ExpiresAt)
+	}
+
+	Set(ctx, 1, "waiting_for_answers", nil, 0)
+	if s := Get(ctx, 1); !s.ExpiresAt.IsZero() || s.Expired(time.Now().Add(365*24*time.Hour)) {
+		t.Errorf("state without TTL expires at %v", s.ExpiresAt)
+	}
+
+	Delete(ctx, 1)
+	if s := Get(ctx, 1); s.Name != "" {
+		t.Errorf("state after Delete = %q", s.Name)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	Use(NewMemoryStore())
+	t.Cleanup(func() { Use(NewMemoryStore()) })
+
+	Set(ctx, 1, "waiting_for_answers", nil, time.Hour)
+	resolved := Resolve(ctx, 1)
+	Delete(ctx, 1)
+
+	if s := Current(resolved, 1); s.Name != "waiting_for_answers" {
+		t.Errorf("Current = %q, want the state read by Resolve", s.Name)
+	}
+	if s := Current(resolved, 2); s.Name != "" {
+		t.Errorf("Current of another chat = %q", s.Name)
+	}
+}
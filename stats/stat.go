@@ -1,4 +0,0 @@
-package stats
-
-
-var UsStats = make(map[int64]string)
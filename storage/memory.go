@@ -0,0 +1,769 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"tgbot/models"
+	"time"
+)
+
+// NewMemoryRepos returns repositories that keep everything in memory and
+// behave like the Postgres ones, for running the bot logic without a
+// database. All of them share one set of tables, so leaderboards and rates
+// see the users and submissions added through the other repositories.
+func NewMemoryRepos() *Repos {
+	m := &memory{
+		users:        map[int64]*memoryUser{},
+		admins:       map[int64]string{},
+		tests:        map[int]*models.Test{},
+		answerKeys:   map[int]string{},
+		files:        map[int]memoryFile{},
+		sessions:     map[sessionKey]models.TestSession{},
+		certificates: map[string]models.Certificate{},
+		settings:     map[string]string{},
+	}
+	return &Repos{
+		Users:        memoryUsers{m},
+		Channels:     memoryChannels{m},
+		Admins:       memoryAdmins{m},
+		Tests:        memoryTests{m},
+		Files:        memoryFiles{m},
+		Submissions:  memorySubmissions{m},
+		Sessions:     memorySessions{m},
+		Certificates: memoryCertificates{m},
+		Settings:     memorySettings{m},
+		Audit:        memoryAudit{m},
+	}
+}
+
+type memory struct {
+	mu sync.Mutex
+
+	users        map[int64]*memoryUser
+	userSeq      int
+	channels     []string
+	admins       map[int64]string
+	tests        map[int]*models.Test
+	testSeq      int
+	answerKeys   map[int]string
+	files        map[int]memoryFile
+	submissions  []memorySubmission
+	sessions     map[sessionKey]models.TestSession
+	certificates map[string]models.Certificate
+	settings     map[string]string
+	audit        []models.AuditEntry
+}
+
+type memoryUser struct {
+	user models.User
+	// rate is nil until UpdateRate runs, like the NULL column.
+	rate      *float64
+	createdAt time.Time
+	seq       int
+}
+
+type memoryFile struct {
+	fileID, fileName, mimeType string
+	data                       []byte
+}
+
+type memorySubmission struct {
+	submission models.Submission
+	voided     bool
+}
+
+type sessionKey struct {
+	userID int64
+	testID int
+}
+
+// bestSubmissions returns every user's best submission for the test, ties
+// broken by the earlier one, ordered by user ID.
+func (m *memory) bestSubmissions(testID int) []models.Submission {
+	best := map[int64]models.Submission{}
+	for _, s := range m.submissions {
+		if s.voided || s.submission.TestID != testID {
+			continue
+		}
+		current, ok := best[s.submission.UserID]
+		if !ok || s.submission.Score > current.Score ||
+			(s.submission.Score == current.Score && s.submission.CreatedAt.Before(current.CreatedAt)) {
+			best[s.submission.UserID] = s.submission
+		}
+	}
+
+	submissions := make([]models.Submission, 0, len(best))
+	for _, s := range best {
+		submissions = append(submissions, s)
+	}
+	sort.Slice(submissions, func(i, j int) bool { return submissions[i].UserID < submissions[j].UserID })
+	return submissions
+}
+
+func matchesFilter(user models.User, filter models.RankFilter) bool {
+	return (filter.Region == "" || strings.EqualFold(user.Region, filter.Region)) &&
+		(filter.District == "" || strings.EqualFold(user.District, filter.District)) &&
+		(filter.School == "" || user.School == filter.School) &&
+		(filter.Grade == "" || user.Grade == filter.Grade)
+}
+
+// rankEntries numbers the sorted entries and keeps the top limit plus userID.
+func rankEntries(sorted []models.RankEntry, limit int, userID int64) []models.RankEntry {
+	var entries []models.RankEntry
+	for i, entry := range sorted {
+		entry.Rank = i + 1
+		if entry.Rank <= limit || entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+type memoryUsers struct {
+	*memory
+}
+
+func (r memoryUsers) Add(ctx context.Context, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[userID]; !ok {
+		r.userSeq++
+		r.users[userID] = &memoryUser{
+			user:      models.User{ID: userID, Status: 1},
+			createdAt: time.Now(),
+			seq:       r.userSeq,
+		}
+	}
+	return nil
+}
+
+func (r memoryUsers) Get(ctx context.Context, userID int64) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return u.user, nil
+}
+
+func (r memoryUsers) All(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	for _, user := range r.sorted() {
+		users = append(users, models.User{ID: user.ID, Status: user.Status})
+	}
+	return users, nil
+}
+
+func (r memoryUsers) AllDetailed(ctx context.Context) ([]models.User, error) {
+	return r.sorted(), nil
+}
+
+// sorted returns the users in the order they joined.
+func (r memoryUsers) sorted() []models.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rows := make([]*memoryUser, 0, len(r.users))
+	for _, u := range r.users {
+		rows = append(rows, u)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].seq < rows[j].seq })
+
+	users := make([]models.User, len(rows))
+	for i, u := range rows {
+		users[i] = u.user
+	}
+	return users
+}
+
+func (r memoryUsers) UpdateFullName(ctx context.Context, userID int64, fullName string) error {
+	return r.update(userID, func(u *models.User) { u.FullName = fullName })
+}
+
+func (r memoryUsers) UpdateRegion(ctx context.Context, userID int64, region string) error {
+	return r.update(userID, func(u *models.User) { u.Region = region })
+}
+
+func (r memoryUsers) UpdateDistrict(ctx context.Context, userID int64, district string) error {
+	return r.update(userID, func(u *models.User) { u.District = district })
+}
+
+func (r memoryUsers) UpdateSchool(ctx context.Context, userID int64, school string) error {
+	return r.update(userID, func(u *models.User) { u.School = school })
+}
+
+func (r memoryUsers) UpdateGrade(ctx context.Context, userID int64, grade string) error {
+	return r.update(userID, func(u *models.User) { u.Grade = grade })
+}
+
+func (r memoryUsers) UpdatePhone(ctx context.Context, userID int64, phone string) error {
+	return r.update(userID, func(u *models.User) { u.Phone = phone })
+}
+
+// update changes an existing user; unknown users are ignored like an UPDATE
+// matching no rows.
+func (r memoryUsers) update(userID int64, change func(*models.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u, ok := r.users[userID]; ok {
+		change(&u.user)
+	}
+	return nil
+}
+
+func (r memoryUsers) Count(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.users), nil
+}
+
+func (r memoryUsers) CountSince(ctx context.Context, since time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, u := range r.users {
+		if !u.createdAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memoryUsers) UpdateRate(ctx context.Context, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		return nil
+	}
+
+	best := map[int]float64{}
+	for _, s := range r.submissions {
+		if s.voided || s.submission.UserID != userID {
+			continue
+		}
+		if test, ok := r.tests[s.submission.TestID]; !ok || !test.ResultsVisible() {
+			continue
+		}
+		if score, ok := best[s.submission.TestID]; !ok || s.submission.Score > score {
+			best[s.submission.TestID] = s.submission.Score
+		}
+	}
+
+	sum := 0.0
+	for _, score := range best {
+		sum += score
+	}
+	u.rate = &sum
+	return nil
+}
+
+func (r memoryUsers) Leaderboard(ctx context.Context, filter models.RankFilter, limit int, userID int64) ([]models.RankEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rows []*memoryUser
+	for _, u := range r.users {
+		if u.rate != nil && matchesFilter(u.user, filter) {
+			rows = append(rows, u)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if *rows[i].rate != *rows[j].rate {
+			return *rows[i].rate > *rows[j].rate
+		}
+		return rows[i].seq < rows[j].seq
+	})
+
+	sorted := make([]models.RankEntry, len(rows))
+	for i, u := range rows {
+		sorted[i] = models.RankEntry{
+			UserID:      u.user.ID,
+			FullName:    u.user.FullName,
+			Score:       *u.rate,
+			SubmittedAt: u.createdAt,
+		}
+	}
+	return rankEntries(sorted, limit, userID), nil
+}
+
+type memoryChannels struct {
+	*memory
+}
+
+func (r memoryChannels) Add(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.channels = append(r.channels, name)
+	return nil
+}
+
+func (r memoryChannels) Delete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.channels[:0]
+	for _, channel := range r.channels {
+		if channel != name {
+			kept = append(kept, channel)
+		}
+	}
+	r.channels = kept
+	return nil
+}
+
+func (r memoryChannels) All(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.channels...), nil
+}
+
+type memoryAdmins struct {
+	*memory
+}
+
+func (r memoryAdmins) Add(ctx context.Context, adminID int64, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.admins[adminID] = role
+	return nil
+}
+
+func (r memoryAdmins) Seed(ctx context.Context, adminID int64, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.admins[adminID]; !ok {
+		r.admins[adminID] = role
+	}
+	return nil
+}
+
+func (r memoryAdmins) Role(ctx context.Context, userID int64) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	role, ok := r.admins[userID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return role, nil
+}
+
+func (r memoryAdmins) IsAdmin(ctx context.Context, userID int64) bool {
+	_, err := r.Role(ctx, userID)
+	return err == nil
+}
+
+func (r memoryAdmins) Remove(ctx context.Context, adminID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.admins, adminID)
+	return nil
+}
+
+type memoryTests struct {
+	*memory
+}
+
+func (r memoryTests) Create(ctx context.Context, title string, createdBy int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.testSeq++
+	r.tests[r.testSeq] = &models.Test{
+		ID:                r.testSeq,
+		Title:             title,
+		CreatedBy:         createdBy,
+		Status:            models.TestStatusDraft,
+		MaxAttempts:       1,
+		ResultsVisibility: models.ResultsImmediate,
+		CreatedAt:         time.Now(),
+	}
+	return r.testSeq, nil
+}
+
+func (r memoryTests) Get(ctx context.Context, testID int) (models.Test, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	test, ok := r.tests[testID]
+	if !ok {
+		return models.Test{}, ErrNotFound
+	}
+	return *test, nil
+}
+
+func (r memoryTests) Active(ctx context.Context) ([]models.Test, error) {
+	now := time.Now()
+	return r.list(func(t models.Test) bool { return t.IsOpen(now) }, false), nil
+}
+
+func (r memoryTests) Expired(ctx context.Context) ([]models.Test, error) {
+	now := time.Now()
+	return r.list(func(t models.Test) bool {
+		return t.Status == models.TestStatusActive && !t.ClosesAt.IsZero() && !now.Before(t.ClosesAt)
+	}, false), nil
+}
+
+func (r memoryTests) All(ctx context.Context) ([]models.Test, error) {
+	return r.list(func(models.Test) bool { return true }, true), nil
+}
+
+// list returns the matching tests ordered by ID.
+func (r memoryTests) list(match func(models.Test) bool, newestFirst bool) []models.Test {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var tests []models.Test
+	for _, test := range r.tests {
+		if match(*test) {
+			tests = append(tests, *test)
+		}
+	}
+	sort.Slice(tests, func(i, j int) bool { return (tests[i].ID < tests[j].ID) != newestFirst })
+	return tests
+}
+
+func (r memoryTests) UpdateSubject(ctx context.Context, testID int, subject string) error {
+	return r.update(testID, func(t *models.Test) { t.Subject = subject })
+}
+
+func (r memoryTests) UpdateStatus(ctx context.Context, testID int, status string) error {
+	return r.update(testID, func(t *models.Test) { t.Status = status })
+}
+
+func (r memoryTests) UpdateMaxAttempts(ctx context.Context, testID int, maxAttempts int) error {
+	return r.update(testID, func(t *models.Test) { t.MaxAttempts = maxAttempts })
+}
+
+func (r memoryTests) UpdateResultsVisibility(ctx context.Context, testID int, visibility string) error {
+	return r.update(testID, func(t *models.Test) { t.ResultsVisibility = visibility })
+}
+
+func (r memoryTests) MarkResultsPublished(ctx context.Context, testID int) error {
+	return r.update(testID, func(t *models.Test) { t.ResultsPublished = true })
+}
+
+func (r memoryTests) UpdateSchedule(ctx context.Context, testID int, opensAt, closesAt time.Time, durationMinutes int) error {
+	return r.update(testID, func(t *models.Test) {
+		t.OpensAt, t.ClosesAt, t.DurationMinutes = opensAt, closesAt, durationMinutes
+	})
+}
+
+func (r memoryTests) update(testID int, change func(*models.Test)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if test, ok := r.tests[testID]; ok {
+		change(test)
+	}
+	return nil
+}
+
+func (r memoryTests) SetAnswerKey(ctx context.Context, testID int, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tests[testID]; !ok {
+		return fmt.Errorf("test %d does not exist", testID)
+	}
+	r.answerKeys[testID] = key
+	return nil
+}
+
+func (r memoryTests) AnswerKey(ctx context.Context, testID int) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.answerKeys[testID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return key, nil
+}
+
+type memoryFiles struct {
+	*memory
+}
+
+func (r memoryFiles) Save(ctx context.Context, testID int, fileID, fileName, mimeType string, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tests[testID]; !ok {
+		return fmt.Errorf("error inserting file metadata: test %d does not exist", testID)
+	}
+	r.files[testID] = memoryFile{fileID: fileID, fileName: fileName, mimeType: mimeType, data: data}
+	return nil
+}
+
+func (r memoryFiles) Get(ctx context.Context, testID int) (fileID, fileName string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	file, ok := r.files[testID]
+	if !ok {
+		return "", "", ErrNotFound
+	}
+	return file.fileID, file.fileName, nil
+}
+
+type memorySubmissions struct {
+	*memory
+}
+
+func (r memorySubmissions) Add(ctx context.Context, submission models.Submission) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tests[submission.TestID]; !ok {
+		return 0, fmt.Errorf("test %d does not exist", submission.TestID)
+	}
+	submission.ID = len(r.submissions) + 1
+	submission.CreatedAt = time.Now()
+	submission.Correct = append([]bool(nil), submission.Correct...)
+	r.submissions = append(r.submissions, memorySubmission{submission: submission})
+	return submission.ID, nil
+}
+
+func (r memorySubmissions) Best(ctx context.Context, testID int) ([]models.Submission, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bestSubmissions(testID), nil
+}
+
+func (r memorySubmissions) CountAttempts(ctx context.Context, userID int64, testID int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, s := range r.submissions {
+		if !s.voided && s.submission.UserID == userID && s.submission.TestID == testID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memorySubmissions) ResetAttempts(ctx context.Context, userID int64, testID int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var voided int64
+	for i := range r.submissions {
+		s := &r.submissions[i]
+		if !s.voided && s.submission.UserID == userID && s.submission.TestID == testID {
+			s.voided = true
+			voided++
+		}
+	}
+	return voided, nil
+}
+
+func (r memorySubmissions) Leaderboard(ctx context.Context, testID int, filter models.RankFilter, limit int, userID int64) ([]models.RankEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sorted []models.RankEntry
+	for _, s := range r.bestSubmissions(testID) {
+		u, ok := r.users[s.UserID]
+		if !ok || !matchesFilter(u.user, filter) {
+			continue
+		}
+		sorted = append(sorted, models.RankEntry{
+			UserID:      s.UserID,
+			FullName:    u.user.FullName,
+			Score:       s.Score,
+			MaxScore:    s.MaxScore,
+			SubmittedAt: s.CreatedAt,
+		})
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Score != sorted[j].Score {
+			return sorted[i].Score > sorted[j].Score
+		}
+		return sorted[i].SubmittedAt.Before(sorted[j].SubmittedAt)
+	})
+	return rankEntries(sorted, limit, userID), nil
+}
+
+type memorySessions struct {
+	*memory
+}
+
+func (r memorySessions) Start(ctx context.Context, userID int64, testID int, deadline time.Time) (models.TestSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := sessionKey{userID, testID}
+	if session, ok := r.sessions[key]; ok {
+		return session, nil
+	}
+	session := models.TestSession{UserID: userID, TestID: testID, StartedAt: time.Now(), Deadline: deadline}
+	r.sessions[key] = session
+	return session, nil
+}
+
+func (r memorySessions) Get(ctx context.Context, userID int64, testID int) (models.TestSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[sessionKey{userID, testID}]
+	if !ok {
+		return models.TestSession{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (r memorySessions) Delete(ctx context.Context, userID int64, testID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, sessionKey{userID, testID})
+	return nil
+}
+
+func (r memorySessions) ToRemind(ctx context.Context, before time.Time) ([]models.TestSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var sessions []models.TestSession
+	for _, session := range r.sessions {
+		if session.Reminded || session.Deadline.IsZero() || !session.Deadline.After(now) || session.Deadline.After(before) {
+			continue
+		}
+		if r.submittedSince(session) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (r memorySessions) submittedSince(session models.TestSession) bool {
+	for _, s := range r.submissions {
+		if !s.voided && s.submission.UserID == session.UserID && s.submission.TestID == session.TestID &&
+			!s.submission.CreatedAt.Before(session.StartedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r memorySessions) MarkReminded(ctx context.Context, userID int64, testID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := sessionKey{userID, testID}
+	if session, ok := r.sessions[key]; ok {
+		session.Reminded = true
+		r.sessions[key] = session
+	}
+	return nil
+}
+
+type memoryCertificates struct {
+	*memory
+}
+
+func (r memoryCertificates) Add(ctx context.Context, certificate models.Certificate) (models.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, issued := range r.certificates {
+		if issued.UserID == certificate.UserID && issued.TestID == certificate.TestID {
+			return issued, nil
+		}
+	}
+	if _, ok := r.certificates[certificate.Code]; ok {
+		return certificate, fmt.Errorf("certificate code %s is already used", certificate.Code)
+	}
+	certificate.CreatedAt = time.Now()
+	r.certificates[certificate.Code] = certificate
+	return certificate, nil
+}
+
+func (r memoryCertificates) ByCode(ctx context.Context, code string) (models.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certificate, ok := r.certificates[code]
+	if !ok {
+		return models.Certificate{}, ErrNotFound
+	}
+	return certificate, nil
+}
+
+type memorySettings struct {
+	*memory
+}
+
+func (r memorySettings) Get(ctx context.Context, key string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	value, ok := r.settings[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (r memorySettings) Set(ctx context.Context, key, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settings[key] = value
+	return nil
+}
+
+type memoryAudit struct {
+	*memory
+}
+
+func (r memoryAudit) Add(ctx context.Context, entry models.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry.ID = len(r.audit) + 1
+	entry.CreatedAt = time.Now()
+	r.audit = append(r.audit, entry)
+	return nil
+}
+
+func (r memoryAudit) Page(ctx context.Context, limit, offset int) ([]models.AuditEntry, error) {
+	entries, _ := r.All(ctx)
+	if offset >= len(entries) {
+		return nil, nil
+	}
+	entries = entries[offset:]
+	if limit < len(entries) {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (r memoryAudit) All(ctx context.Context) ([]models.AuditEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]models.AuditEntry, len(r.audit))
+	for i, entry := range r.audit {
+		entries[len(r.audit)-1-i] = entry
+	}
+	return entries, nil
+}
+
+func (r memoryAudit) Count(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.audit), nil
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tgbot/models"
+)
+
+// newTest creates an active test with the given attempt limit and results
+// visibility.
+func newTest(t *testing.T, repos *Repos, maxAttempts int, visibility string) int {
+	t.Helper()
+	ctx := context.Background()
+
+	testID, err := repos.Tests.Create(ctx, "Test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Tests.UpdateMaxAttempts(ctx, testID, maxAttempts); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Tests.UpdateResultsVisibility(ctx, testID, visibility); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Tests.UpdateStatus(ctx, testID, models.TestStatusActive); err != nil {
+		t.Fatal(err)
+	}
+	return testID
+}
+
+func submit(t *testing.T, repos *Repos, userID int64, testID int, score float64) {
+	t.Helper()
+	_, err := repos.Submissions.Add(context.Background(), models.Submission{
+		UserID:   userID,
+		TestID:   testID,
+		Answers:  "1) a",
+		Correct:  []bool{score > 0},
+		Score:    score,
+		MaxScore: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rates returns the overall leaderboard as user ID to score.
+func rates(t *testing.T, repos *Repos) map[int64]float64 {
+	t.Helper()
+	entries, err := repos.Users.Leaderboard(context.Background(), models.RankFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[int64]float64)
+	for _, entry := range entries {
+		result[entry.UserID] = entry.Score
+	}
+	return result
+}
+
+func TestRateLeavesOutHiddenResults(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemoryRepos()
+	repos.Users.Add(ctx, 2)
+
+	visible := newTest(t, repos, 0, models.ResultsImmediate)
+	hidden := newTest(t, repos, 0, models.ResultsManual)
+	submit(t, repos, 2, visible, 1)
+	submit(t, repos, 2, hidden, 2)
+	if err := repos.Users.UpdateRate(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := rates(t, repos)[2]; got != 1 {
+		t.Errorf("rate before publishing = %v, want 1", got)
+	}
+
+	if err := repos.Tests.MarkResultsPublished(ctx, hidden); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Users.UpdateRate(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := rates(t, repos)[2]; got != 3 {
+		t.Errorf("rate after publishing = %v, want 3", got)
+	}
+}
+
+func TestRateKeepsFractionalScores(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemoryRepos()
+	repos.Users.Add(ctx, 2)
+	repos.Users.Add(ctx, 3)
+
+	first := newTest(t, repos, 0, models.ResultsImmediate)
+	second := newTest(t, repos, 0, models.ResultsImmediate)
+	submit(t, repos, 2, first, 1.25)
+	submit(t, repos, 2, second, 2.1)
+	submit(t, repos, 3, first, 3.4)
+	repos.Users.UpdateRate(ctx, 2)
+	repos.Users.UpdateRate(ctx, 3)
+
+	got := rates(t, repos)
+	if got[2] != 1.25+2.1 || got[3] != 3.4 {
+		t.Errorf("rates = %v, want 3.35 and 3.4", got)
+	}
+	entries, _ := repos.Users.Leaderboard(ctx, models.RankFilter{}, 10, 0)
+	if len(entries) != 2 || entries[0].UserID != 3 {
+		t.Errorf("leaderboard = %+v, want user 3 ahead by a fraction of a point", entries)
+	}
+}
+
+func TestAttemptsAndVoiding(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemoryRepos()
+	repos.Users.Add(ctx, 2)
+	repos.Users.Add(ctx, 3)
+	testID := newTest(t, repos, 2, models.ResultsImmediate)
+	other := newTest(t, repos, 2, models.ResultsImmediate)
+
+	submit(t, repos, 2, testID, 3)
+	submit(t, repos, 2, testID, 1)
+	submit(t, repos, 2, other, 1)
+	submit(t, repos, 3, testID, 2)
+
+	if n, err := repos.Submissions.CountAttempts(ctx, 2, testID); err != nil || n != 2 {
+		t.Fatalf("CountAttempts = %d, %v, want 2", n, err)
+	}
+
+	voided, err := repos.Submissions.ResetAttempts(ctx, 2, testID)
+	if err != nil || voided != 2 {
+		t.Fatalf("ResetAttempts = %d, %v, want 2", voided, err)
+	}
+	if n, _ := repos.Submissions.CountAttempts(ctx, 2, testID); n != 0 {
+		t.Errorf("attempts after reset = %d, want 0", n)
+	}
+	if n, _ := repos.Submissions.CountAttempts(ctx, 2, other); n != 1 {
+		t.Errorf("attempts on another test after reset = %d, want 1", n)
+	}
+	if voided, _ := repos.Submissions.ResetAttempts(ctx, 2, testID); voided != 0 {
+		t.Errorf("second reset voided %d", voided)
+	}
+
+	// Voided submissions no longer count as the best one
+	best, err := repos.Submissions.Best(ctx, testID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(best) != 1 || best[0].UserID != 3 {
+		t.Errorf("Best after reset = %+v, want only user 3", best)
+	}
+	repos.Users.UpdateRate(ctx, 2)
+	if got := rates(t, repos)[2]; got != 1 {
+		t.Errorf("rate after reset = %v, want 1", got)
+	}
+}
+
+func TestTestLeaderboardOrder(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemoryRepos()
+	for _, userID := range []int64{2, 3, 4, 5} {
+		repos.Users.Add(ctx, userID)
+	}
+	testID := newTest(t, repos, 0, models.ResultsImmediate)
+
+	submit(t, repos, 2, testID, 1)
+	submit(t, repos, 3, testID, 2)
+	submit(t, repos, 4, testID, 2)
+	submit(t, repos, 2, testID, 3)
+	submit(t, repos, 5, testID, 0)
+
+	entries, err := repos.Submissions.Leaderboard(ctx, testID, models.RankFilter{}, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Best scores rank first and ties go to whoever submitted first; the
+	// asking user is added after the top
+	want := []struct {
+		rank   int
+		userID int64
+		score  float64
+	}{{1, 2, 3}, {2, 3, 2}, {3, 4, 2}, {4, 5, 0}}
+	if len(entries) != len(want) {
+		t.Fatalf("Leaderboard = %+v, want %d entries", entries, len(want))
+	}
+	for i, w := range want {
+		if e := entries[i]; e.Rank != w.rank || e.UserID != w.userID || e.Score != w.score {
+			t.Errorf("entry %d = %+v, want rank %d user %d score %v", i, e, w.rank, w.userID, w.score)
+		}
+	}
+
+	only, err := repos.Submissions.Leaderboard(ctx, testID, models.RankFilter{}, 0, 4)
+	if err != nil || len(only) != 1 || only[0].UserID != 4 || only[0].Rank != 3 {
+		t.Errorf("Leaderboard with limit 0 = %+v, %v, want only user 4 at rank 3", only, err)
+	}
+}
+
+func TestUserLeaderboardOrder(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemoryRepos()
+	for _, userID := range []int64{2, 3, 4} {
+		repos.Users.Add(ctx, userID)
+	}
+	testID := newTest(t, repos, 0, models.ResultsImmediate)
+	submit(t, repos, 2, testID, 1)
+	submit(t, repos, 3, testID, 3)
+	submit(t, repos, 4, testID, 1)
+	for _, userID := range []int64{4, 3, 2} {
+		repos.Users.UpdateRate(ctx, userID)
+	}
+
+	entries, err := repos.Users.Leaderboard(ctx, models.RankFilter{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Equal rates go to whoever joined first
+	var order []int64
+	for _, e := range entries {
+		order = append(order, e.UserID)
+	}
+	if len(order) != 3 || order[0] != 3 || order[1] != 2 || order[2] != 4 {
+		t.Errorf("order = %v, want [3 2 4]", order)
+	}
+}
+
+func TestSessionStartKeepsExisting(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemoryRepos()
+	deadline := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	first, err := repos.Sessions.Start(ctx, 2, 1, deadline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := repos.Sessions.Start(ctx, 2, 1, deadline.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Deadline.Equal(deadline) || !again.StartedAt.Equal(first.StartedAt) {
+		t.Errorf("restarted session = %+v, want %+v", again, first)
+	}
+
+	if err := repos.Sessions.Delete(ctx, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repos.Sessions.Get(ctx, 2, 1); err != ErrNotFound {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCertificateAddKeepsExisting(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemoryRepos()
+
+	issued, err := repos.Certificates.Add(ctx, models.Certificate{Code: "AAAA", UserID: 2, TestID: 1, Rank: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := repos.Certificates.Add(ctx, models.Certificate{Code: "BBBB", UserID: 2, TestID: 1, Rank: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Code != issued.Code || again.Rank != 1 {
+		t.Errorf("second Add = %+v, want the first certificate", again)
+	}
+	if _, err := repos.Certificates.ByCode(ctx, "BBBB"); err != ErrNotFound {
+		t.Errorf("ByCode of the dropped certificate = %v, want ErrNotFound", err)
+	}
+
+	if _, err := repos.Certificates.Add(ctx, models.Certificate{Code: "AAAA", UserID: 3, TestID: 1}); err == nil {
+		t.Error("a used code was accepted for another user")
+	}
+}
@@ -0,0 +1,731 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"tgbot/metrics"
+	"tgbot/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func OpenDatabase(connStr string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// NewPostgresRepos returns repositories backed by the database. Every query
+// is cancelled after timeout even if the caller's context allows more.
+func NewPostgresRepos(conn *sql.DB, timeout time.Duration) *Repos {
+	db := NewDB(conn, timeout)
+	return &Repos{
+		Users:        postgresUsers{db},
+		Channels:     postgresChannels{db},
+		Admins:       postgresAdmins{db},
+		Tests:        postgresTests{db},
+		Files:        postgresFiles{db},
+		Submissions:  postgresSubmissions{db},
+		Sessions:     postgresSessions{db},
+		Certificates: postgresCertificates{db},
+		Settings:     postgresSettings{db},
+		Audit:        postgresAudit{db},
+	}
+}
+
+// DB runs queries with a deadline so that a hung connection cannot block a
+// handler for good, and records how long they take in the metrics. Stores
+// outside this package that keep their data in the same database use it too.
+type DB struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// NewDB cancels every query on conn after timeout even if the caller's
+// context allows more.
+func NewDB(conn *sql.DB, timeout time.Duration) DB {
+	return DB{db: conn, timeout: timeout}
+}
+
+func (d DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	defer observeQuery("exec", time.Now())
+	return d.db.ExecContext(ctx, query, args...)
+}
+
+// QueryContext returns rows whose deadline ends when they are closed. Only the
+// time until the first row is available is recorded.
+func (d DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	start := time.Now()
+	result, err := d.db.QueryContext(ctx, query, args...)
+	observeQuery("query", start)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Rows{Rows: result, cancel: cancel}, nil
+}
+
+// QueryRowContext works like sql.DB.QueryRowContext; the deadline ends once
+// the row is scanned.
+func (d DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	result, err := d.QueryContext(ctx, query, args...)
+	return &Row{rows: result, err: err}
+}
+
+func observeQuery(operation string, start time.Time) {
+	metrics.QueryDuration.Observe(time.Since(start).Seconds(), operation)
+}
+
+// Rows are the result of DB.QueryContext.
+type Rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+// Row is the result of DB.QueryRowContext.
+type Row struct {
+	rows *Rows
+	err  error
+}
+
+func (r *Row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+	return r.rows.Close()
+}
+
+// notFound turns sql.ErrNoRows into ErrNotFound.
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type postgresUsers struct {
+	db DB
+}
+
+func (r postgresUsers) Add(ctx context.Context, userID int64) error {
+	query := `INSERT INTO users (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+func (r postgresUsers) Get(ctx context.Context, userID int64) (models.User, error) {
+	var (
+		user        models.User
+		fullNameStr sql.NullString
+		regionStr   sql.NullString
+		districtStr sql.NullString
+		schoolStr   sql.NullString
+		gradeStr    sql.NullString
+		phoneStr    sql.NullString
+	)
+
+	query := `SELECT user_id, full_name, region, district, school, grade, phone FROM users WHERE user_id = $1`
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&user.ID, &fullNameStr, &regionStr, &districtStr, &schoolStr, &gradeStr, &phoneStr)
+
+	user.FullName = fullNameStr.String
+	user.Region = regionStr.String
+	user.District = districtStr.String
+	user.School = schoolStr.String
+	user.Grade = gradeStr.String
+	user.Phone = phoneStr.String
+	return user, notFound(err)
+}
+
+func (r postgresUsers) All(ctx context.Context) ([]models.User, error) {
+	slog.DebugContext(ctx, "GetAllUsers funksiyasi ishga tushdi") // Log qo'shish
+	query := `SELECT user_id, status FROM users`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Status); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+func (r postgresUsers) AllDetailed(ctx context.Context) ([]models.User, error) {
+	query := `SELECT user_id, full_name, region, district, school, grade, phone FROM users`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.FullName, &user.Region, &user.District, &user.School, &user.Grade, &user.Phone); err != nil {
+			slog.ErrorContext(ctx, "Error scanning user", "err", err)
+			continue
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+func (r postgresUsers) UpdateFullName(ctx context.Context, userID int64, fullName string) error {
+	return r.update(ctx, "full_name", userID, fullName)
+}
+
+func (r postgresUsers) UpdateRegion(ctx context.Context, userID int64, region string) error {
+	return r.update(ctx, "region", userID, region)
+}
+
+func (r postgresUsers) UpdateDistrict(ctx context.Context, userID int64, district string) error {
+	return r.update(ctx, "district", userID, district)
+}
+
+func (r postgresUsers) UpdateSchool(ctx context.Context, userID int64, school string) error {
+	return r.update(ctx, "school", userID, school)
+}
+
+func (r postgresUsers) UpdateGrade(ctx context.Context, userID int64, grade string) error {
+	return r.update(ctx, "grade", userID, grade)
+}
+
+func (r postgresUsers) UpdatePhone(ctx context.Context, userID int64, phone string) error {
+	return r.update(ctx, "phone", userID, phone)
+}
+
+// update sets one registration column; column is never user input.
+func (r postgresUsers) update(ctx context.Context, column string, userID int64, value string) error {
+	query := `UPDATE users SET ` + column + ` = $1 WHERE user_id = $2`
+	_, err := r.db.ExecContext(ctx, query, value, userID)
+	return err
+}
+
+func (r postgresUsers) Count(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+func (r postgresUsers) CountSince(ctx context.Context, since time.Time) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE created_at >= $1", since).Scan(&count)
+	return count, err
+}
+
+func (r postgresUsers) UpdateRate(ctx context.Context, userID int64) error {
+	query := `UPDATE users SET rate = (
+		SELECT COALESCE(SUM(best), 0) FROM (
+			SELECT MAX(s.score) AS best
+			FROM submissions s
+			JOIN tests t ON t.id = s.test_id
+			WHERE s.user_id = $1 AND NOT s.voided
+				AND (t.results_visibility = '` + models.ResultsImmediate + `' OR t.results_published)
+			GROUP BY s.test_id
+		) AS best_scores
+	) WHERE user_id = $1`
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+const rankFilterCondition = `($1 = '' OR LOWER(u.region) = LOWER($1))
+	AND ($2 = '' OR LOWER(u.district) = LOWER($2))
+	AND ($3 = '' OR u.school = $3)
+	AND ($4 = '' OR u.grade = $4)`
+
+func (r postgresUsers) Leaderboard(ctx context.Context, filter models.RankFilter, limit int, userID int64) ([]models.RankEntry, error) {
+	query := `WITH ranked AS (
+			SELECT ROW_NUMBER() OVER (ORDER BY u.rate DESC, u.created_at) AS rank,
+				u.user_id, COALESCE(u.full_name, ''), u.rate, 0::float8, u.created_at
+			FROM users u
+			WHERE u.rate IS NOT NULL AND ` + rankFilterCondition + `
+		)
+		SELECT * FROM ranked WHERE rank <= $5 OR user_id = $6 ORDER BY rank`
+	return getRankEntries(ctx, r.db, query, filter.Region, filter.District, filter.School, filter.Grade, limit, userID)
+}
+
+func getRankEntries(ctx context.Context, db DB, query string, args ...interface{}) ([]models.RankEntry, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.RankEntry
+	for rows.Next() {
+		var entry models.RankEntry
+		if err := rows.Scan(&entry.Rank, &entry.UserID, &entry.FullName, &entry.Score, &entry.MaxScore, &entry.SubmittedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+type postgresChannels struct {
+	db DB
+}
+
+func (r postgresChannels) Add(ctx context.Context, name string) error {
+	query := `INSERT INTO channels (name) VALUES ($1)`
+	_, err := r.db.ExecContext(ctx, query, name)
+	return err
+}
+
+func (r postgresChannels) Delete(ctx context.Context, name string) error {
+	query := `DELETE FROM channels WHERE name = $1`
+	_, err := r.db.ExecContext(ctx, query, name)
+	return err
+}
+
+func (r postgresChannels) All(ctx context.Context) ([]string, error) {
+	query := `SELECT name FROM channels`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []string
+	for rows.Next() {
+		var channel string
+		if err := rows.Scan(&channel); err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+
+	return channels, nil
+}
+
+type postgresAdmins struct {
+	db DB
+}
+
+func (r postgresAdmins) Add(ctx context.Context, adminID int64, role string) error {
+	query := `INSERT INTO admins (id, role) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET role = $2`
+	_, err := r.db.ExecContext(ctx, query, adminID, role)
+	return err
+}
+
+func (r postgresAdmins) Seed(ctx context.Context, adminID int64, role string) error {
+	query := `INSERT INTO admins (id, role) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, adminID, role)
+	return err
+}
+
+func (r postgresAdmins) Role(ctx context.Context, userID int64) (string, error) {
+	var role string
+	query := `SELECT role FROM admins WHERE id = $1`
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&role)
+	return role, notFound(err)
+}
+
+func (r postgresAdmins) IsAdmin(ctx context.Context, userID int64) bool {
+	var id int64
+	query := `SELECT id FROM admins WHERE id = $1`
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&id)
+	return err == nil
+}
+
+func (r postgresAdmins) Remove(ctx context.Context, adminID int64) error {
+	query := `DELETE FROM admins WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, adminID)
+	return err
+}
+
+type postgresTests struct {
+	db DB
+}
+
+const testColumns = `id, title, subject, created_by, status, max_attempts, results_visibility, results_published, opens_at, closes_at, duration_minutes, created_at`
+
+func scanTest(row rowScanner) (models.Test, error) {
+	var (
+		test       models.Test
+		subjectStr sql.NullString
+		createdBy  sql.NullInt64
+		opensAt    sql.NullTime
+		closesAt   sql.NullTime
+	)
+
+	err := row.Scan(&test.ID, &test.Title, &subjectStr, &createdBy, &test.Status, &test.MaxAttempts, &test.ResultsVisibility, &test.ResultsPublished, &opensAt, &closesAt, &test.DurationMinutes, &test.CreatedAt)
+	test.Subject = subjectStr.String
+	test.CreatedBy = createdBy.Int64
+	test.OpensAt = opensAt.Time
+	test.ClosesAt = closesAt.Time
+	return test, err
+}
+
+func (r postgresTests) Create(ctx context.Context, title string, createdBy int64) (int, error) {
+	var testID int
+	query := `INSERT INTO tests (title, created_by, status) VALUES ($1, $2, $3) RETURNING id`
+	err := r.db.QueryRowContext(ctx, query, title, createdBy, models.TestStatusDraft).Scan(&testID)
+	return testID, err
+}
+
+func (r postgresTests) Get(ctx context.Context, testID int) (models.Test, error) {
+	query := `SELECT ` + testColumns + ` FROM tests WHERE id = $1`
+	test, err := scanTest(r.db.QueryRowContext(ctx, query, testID))
+	return test, notFound(err)
+}
+
+func (r postgresTests) Active(ctx context.Context) ([]models.Test, error) {
+	return r.list(ctx, `SELECT `+testColumns+` FROM tests WHERE status = $1
+		AND (opens_at IS NULL OR opens_at <= NOW()) AND (closes_at IS NULL OR closes_at > NOW())
+		ORDER BY id`, models.TestStatusActive)
+}
+
+func (r postgresTests) Expired(ctx context.Context) ([]models.Test, error) {
+	return r.list(ctx, `SELECT `+testColumns+` FROM tests WHERE status = $1 AND closes_at <= NOW() ORDER BY id`, models.TestStatusActive)
+}
+
+func (r postgresTests) All(ctx context.Context) ([]models.Test, error) {
+	return r.list(ctx, `SELECT `+testColumns+` FROM tests ORDER BY id DESC`)
+}
+
+func (r postgresTests) list(ctx context.Context, query string, args ...interface{}) ([]models.Test, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tests []models.Test
+	for rows.Next() {
+		test, err := scanTest(rows)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+
+	return tests, rows.Err()
+}
+
+func (r postgresTests) UpdateSubject(ctx context.Context, testID int, subject string) error {
+	query := `UPDATE tests SET subject = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, subject, testID)
+	return err
+}
+
+func (r postgresTests) UpdateStatus(ctx context.Context, testID int, status string) error {
+	query := `UPDATE tests SET status = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, status, testID)
+	return err
+}
+
+func (r postgresTests) UpdateMaxAttempts(ctx context.Context, testID int, maxAttempts int) error {
+	query := `UPDATE tests SET max_attempts = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, maxAttempts, testID)
+	return err
+}
+
+func (r postgresTests) UpdateResultsVisibility(ctx context.Context, testID int, visibility string) error {
+	query := `UPDATE tests SET results_visibility = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, visibility, testID)
+	return err
+}
+
+func (r postgresTests) MarkResultsPublished(ctx context.Context, testID int) error {
+	query := `UPDATE tests SET results_published = TRUE WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, testID)
+	return err
+}
+
+func (r postgresTests) UpdateSchedule(ctx context.Context, testID int, opensAt, closesAt time.Time, durationMinutes int) error {
+	query := `UPDATE tests SET opens_at = $1, closes_at = $2, duration_minutes = $3 WHERE id = $4`
+	_, err := r.db.ExecContext(ctx, query, nullTime(opensAt), nullTime(closesAt), durationMinutes, testID)
+	return err
+}
+
+func (r postgresTests) SetAnswerKey(ctx context.Context, testID int, key string) error {
+	query := `INSERT INTO answers (test_id, answers) VALUES ($1, $2)
+		ON CONFLICT (test_id) DO UPDATE SET answers = $2`
+	_, err := r.db.ExecContext(ctx, query, testID, key)
+	return err
+}
+
+func (r postgresTests) AnswerKey(ctx context.Context, testID int) (string, error) {
+	query := `SELECT answers FROM answers WHERE test_id = $1`
+	var answers string
+	err := r.db.QueryRowContext(ctx, query, testID).Scan(&answers)
+	return answers, notFound(err)
+}
+
+type postgresFiles struct {
+	db DB
+}
+
+func (r postgresFiles) Save(ctx context.Context, testID int, fileID, fileName, mimeType string, data []byte) error {
+	query := `INSERT INTO files (test_id, file_id, file_name, mime_type, file_data) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (test_id) DO UPDATE SET file_id = $2, file_name = $3, mime_type = $4, file_data = $5, created_at = NOW()`
+	_, err := r.db.ExecContext(ctx, query, testID, fileID, fileName, mimeType, data)
+	if err != nil {
+		return fmt.Errorf("error inserting file metadata: %v", err)
+	}
+
+	return nil
+}
+
+func (r postgresFiles) Get(ctx context.Context, testID int) (fileID, fileName string, err error) {
+	query := `SELECT file_id, file_name FROM files WHERE test_id = $1`
+	err = notFound(r.db.QueryRowContext(ctx, query, testID).Scan(&fileID, &fileName))
+	return
+}
+
+type postgresSubmissions struct {
+	db DB
+}
+
+func (r postgresSubmissions) Add(ctx context.Context, submission models.Submission) (int, error) {
+	var submissionID int
+	query := `INSERT INTO submissions (user_id, test_id, answers, correct, score, max_score) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	err := r.db.QueryRowContext(ctx, query, submission.UserID, submission.TestID, submission.Answers, pq.Array(submission.Correct), submission.Score, submission.MaxScore).Scan(&submissionID)
+	return submissionID, err
+}
+
+func (r postgresSubmissions) Best(ctx context.Context, testID int) ([]models.Submission, error) {
+	query := `SELECT DISTINCT ON (user_id) id, user_id, test_id, answers, correct, score, max_score, created_at
+		FROM submissions WHERE test_id = $1 AND NOT voided
+		ORDER BY user_id, score DESC, created_at`
+	rows, err := r.db.QueryContext(ctx, query, testID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var submissions []models.Submission
+	for rows.Next() {
+		var submission models.Submission
+		if err := rows.Scan(&submission.ID, &submission.UserID, &submission.TestID, &submission.Answers, pq.Array(&submission.Correct), &submission.Score, &submission.MaxScore, &submission.CreatedAt); err != nil {
+			return nil, err
+		}
+		submissions = append(submissions, submission)
+	}
+
+	return submissions, rows.Err()
+}
+
+func (r postgresSubmissions) CountAttempts(ctx context.Context, userID int64, testID int) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM submissions WHERE user_id = $1 AND test_id = $2 AND NOT voided`
+	err := r.db.QueryRowContext(ctx, query, userID, testID).Scan(&count)
+	return count, err
+}
+
+func (r postgresSubmissions) ResetAttempts(ctx context.Context, userID int64, testID int) (int64, error) {
+	query := `UPDATE submissions SET voided = TRUE WHERE user_id = $1 AND test_id = $2 AND NOT voided`
+	result, err := r.db.ExecContext(ctx, query, userID, testID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r postgresSubmissions) Leaderboard(ctx context.Context, testID int, filter models.RankFilter, limit int, userID int64) ([]models.RankEntry, error) {
+	query := `WITH best AS (
+			SELECT DISTINCT ON (user_id) user_id, score, max_score, created_at
+			FROM submissions WHERE test_id = $5 AND NOT voided
+			ORDER BY user_id, score DESC, created_at
+		), ranked AS (
+			SELECT ROW_NUMBER() OVER (ORDER BY b.score DESC, b.created_at) AS rank,
+				b.user_id, COALESCE(u.full_name, ''), b.score, b.max_score, b.created_at
+			FROM best b JOIN users u ON u.user_id = b.user_id
+			WHERE ` + rankFilterCondition + `
+		)
+		SELECT * FROM ranked WHERE rank <= $6 OR user_id = $7 ORDER BY rank`
+	return getRankEntries(ctx, r.db, query, filter.Region, filter.District, filter.School, filter.Grade, testID, limit, userID)
+}
+
+type postgresSessions struct {
+	db DB
+}
+
+func (r postgresSessions) Start(ctx context.Context, userID int64, testID int, deadline time.Time) (models.TestSession, error) {
+	query := `INSERT INTO test_sessions (user_id, test_id, deadline) VALUES ($1, $2, $3) ON CONFLICT (user_id, test_id) DO NOTHING`
+	if _, err := r.db.ExecContext(ctx, query, userID, testID, nullTime(deadline)); err != nil {
+		return models.TestSession{}, err
+	}
+	return r.Get(ctx, userID, testID)
+}
+
+func (r postgresSessions) Get(ctx context.Context, userID int64, testID int) (models.TestSession, error) {
+	query := `SELECT user_id, test_id, started_at, deadline, reminded FROM test_sessions WHERE user_id = $1 AND test_id = $2`
+	session, err := scanSession(r.db.QueryRowContext(ctx, query, userID, testID))
+	return session, notFound(err)
+}
+
+func scanSession(row rowScanner) (models.TestSession, error) {
+	var (
+		session  models.TestSession
+		deadline sql.NullTime
+	)
+	err := row.Scan(&session.UserID, &session.TestID, &session.StartedAt, &deadline, &session.Reminded)
+	session.Deadline = deadline.Time
+	return session, err
+}
+
+func (r postgresSessions) Delete(ctx context.Context, userID int64, testID int) error {
+	query := `DELETE FROM test_sessions WHERE user_id = $1 AND test_id = $2`
+	_, err := r.db.ExecContext(ctx, query, userID, testID)
+	return err
+}
+
+func (r postgresSessions) ToRemind(ctx context.Context, before time.Time) ([]models.TestSession, error) {
+	query := `SELECT s.user_id, s.test_id, s.started_at, s.deadline, s.reminded FROM test_sessions s
+		WHERE NOT s.reminded AND s.deadline > NOW() AND s.deadline <= $1
+		AND NOT EXISTS (
+			SELECT 1 FROM submissions sub
+			WHERE sub.user_id = s.user_id AND sub.test_id = s.test_id AND NOT sub.voided AND sub.created_at >= s.started_at
+		)`
+	rows, err := r.db.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.TestSession
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (r postgresSessions) MarkReminded(ctx context.Context, userID int64, testID int) error {
+	query := `UPDATE test_sessions SET reminded = TRUE WHERE user_id = $1 AND test_id = $2`
+	_, err := r.db.ExecContext(ctx, query, userID, testID)
+	return err
+}
+
+type postgresCertificates struct {
+	db DB
+}
+
+func (r postgresCertificates) Add(ctx context.Context, certificate models.Certificate) (models.Certificate, error) {
+	query := `INSERT INTO certificates (code, user_id, test_id, full_name, test_title, score, max_score, rank)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (user_id, test_id) DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, certificate.Code, certificate.UserID, certificate.TestID, certificate.FullName,
+		certificate.TestTitle, certificate.Score, certificate.MaxScore, certificate.Rank)
+	if err != nil {
+		return certificate, err
+	}
+	return r.get(ctx, `WHERE user_id = $1 AND test_id = $2`, certificate.UserID, certificate.TestID)
+}
+
+func (r postgresCertificates) ByCode(ctx context.Context, code string) (models.Certificate, error) {
+	return r.get(ctx, `WHERE code = $1`, code)
+}
+
+func (r postgresCertificates) get(ctx context.Context, where string, args ...interface{}) (models.Certificate, error) {
+	var certificate models.Certificate
+	query := `SELECT code, user_id, test_id, full_name, test_title, score, max_score, rank, created_at FROM certificates ` + where
+	err := r.db.QueryRowContext(ctx, query, args...).Scan(&certificate.Code, &certificate.UserID, &certificate.TestID, &certificate.FullName,
+		&certificate.TestTitle, &certificate.Score, &certificate.MaxScore, &certificate.Rank, &certificate.CreatedAt)
+	return certificate, notFound(err)
+}
+
+type postgresSettings struct {
+	db DB
+}
+
+func (r postgresSettings) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := r.db.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = $1`, key).Scan(&value)
+	return value, notFound(err)
+}
+
+func (r postgresSettings) Set(ctx context.Context, key, value string) error {
+	query := `INSERT INTO settings (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = $2`
+	_, err := r.db.ExecContext(ctx, query, key, value)
+	return err
+}
+
+type postgresAudit struct {
+	db DB
+}
+
+func (r postgresAudit) Add(ctx context.Context, entry models.AuditEntry) error {
+	query := `INSERT INTO audit_log (actor_id, action, target, old_value, new_value) VALUES ($1, $2, $3, $4, $5)`
+	_, err := r.db.ExecContext(ctx, query, entry.ActorID, entry.Action, entry.Target, entry.OldValue, entry.NewValue)
+	return err
+}
+
+func (r postgresAudit) Page(ctx context.Context, limit, offset int) ([]models.AuditEntry, error) {
+	return r.list(ctx, `ORDER BY id DESC LIMIT $1 OFFSET $2`, limit, offset)
+}
+
+func (r postgresAudit) All(ctx context.Context) ([]models.AuditEntry, error) {
+	return r.list(ctx, `ORDER BY id DESC`)
+}
+
+func (r postgresAudit) Count(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log`).Scan(&count)
+	return count, err
+}
+
+func (r postgresAudit) list(ctx context.Context, order string, args ...interface{}) ([]models.AuditEntry, error) {
+	query := `SELECT id, actor_id, action, target, old_value, new_value, created_at FROM audit_log ` + order
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.Target, &entry.OldValue, &entry.NewValue, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"tgbot/models"
+	"time"
+)
+
+// ErrNotFound is returned when the requested row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Repos holds one repository per domain. Handlers get it instead of a
+// database connection, so they run the same against NewPostgresRepos and
+// NewMemoryRepos.
+type Repos struct {
+	Users        UserRepo
+	Channels     ChannelRepo
+	Admins       AdminRepo
+	Tests        TestRepo
+	Files        FileRepo
+	Submissions  SubmissionRepo
+	Sessions     SessionRepo
+	Certificates CertificateRepo
+	Settings     SettingRepo
+	Audit        AuditRepo
+}
+
+type UserRepo interface {
+	// Add registers the user unless they already exist.
+	Add(ctx context.Context, userID int64) error
+	Get(ctx context.Context, userID int64) (models.User, error)
+	// All returns every user with only ID and Status set.
+	All(ctx context.Context) ([]models.User, error)
+	AllDetailed(ctx context.Context) ([]models.User, error)
+	UpdateFullName(ctx context.Context, userID int64, fullName string) error
+	UpdateRegion(ctx context.Context, userID int64, region string) error
+	UpdateDistrict(ctx context.Context, userID int64, district string) error
+	UpdateSchool(ctx context.Context, userID int64, school string) error
+	UpdateGrade(ctx context.Context, userID int64, grade string) error
+	UpdatePhone(ctx context.Context, userID int64, phone string) error
+	Count(ctx context.Context) (int, error)
+	// CountSince counts the users who joined at or after the given time.
+	CountSince(ctx context.Context, since time.Time) (int, error)
+	// UpdateRate sets the user's rate to the sum of their best score on every
+	// test whose results are visible, so hidden scores cannot be read off the
+	// overall leaderboard.
+	UpdateRate(ctx context.Context, userID int64) error
+	// Leaderboard ranks users by their rate, ties broken by who joined first,
+	// and returns the top entries plus the entry of userID if it falls
+	// outside them. A limit of 0 returns only the entry of userID.
+	Leaderboard(ctx context.Context, filter models.RankFilter, limit int, userID int64) ([]models.RankEntry, error)
+}
+
+type ChannelRepo interface {
+	Add(ctx context.Context, name string) error
+	Delete(ctx context.Context, name string) error
+	All(ctx context.Context) ([]string, error)
+}
+
+type AdminRepo interface {
+	// Add makes the user an admin with the role, changing the role of an
+	// existing admin.
+	Add(ctx context.Context, adminID int64, role string) error
+	// Seed adds the admin with the role unless they are already an admin.
+	Seed(ctx context.Context, adminID int64, role string) error
+	// Role returns ErrNotFound if the user is not an admin.
+	Role(ctx context.Context, userID int64) (string, error)
+	IsAdmin(ctx context.Context, userID int64) bool
+	Remove(ctx context.Context, adminID int64) error
+}
+
+type TestRepo interface {
+	// Create adds a draft test and returns its ID.
+	Create(ctx context.Context, title string, createdBy int64) (int, error)
+	Get(ctx context.Context, testID int) (models.Test, error)
+	// Active returns the active tests whose time window is currently open.
+	Active(ctx context.Context) ([]models.Test, error)
+	// Expired returns the active tests whose closing time has passed.
+	Expired(ctx context.Context) ([]models.Test, error)
+	// All returns every test, newest first.
+	All(ctx context.Context) ([]models.Test, error)
+	UpdateSubject(ctx context.Context, testID int, subject string) error
+	UpdateStatus(ctx context.Context, testID int, status string) error
+	UpdateMaxAttempts(ctx context.Context, testID int, maxAttempts int) error
+	UpdateResultsVisibility(ctx context.Context, testID int, visibility string) error
+	MarkResultsPublished(ctx context.Context, testID int) error
+	// UpdateSchedule sets the time window and duration; zero times mean no limit.
+	UpdateSchedule(ctx context.Context, testID int, opensAt, closesAt time.Time, durationMinutes int) error
+	SetAnswerKey(ctx context.Context, testID int, key string) error
+	AnswerKey(ctx context.Context, testID int) (string, error)
+}
+
+type FileRepo interface {
+	// Save stores the test file, replacing the previous one.
+	Save(ctx context.Context, testID int, fileID, fileName, mimeType string, data []byte) error
+	Get(ctx context.Context, testID int) (fileID, fileName string, err error)
+}
+
+type SubmissionRepo interface {
+	Add(ctx context.Context, submission models.Submission) (int, error)
+	// Best returns every participant's best submission for the test.
+	Best(ctx context.Context, testID int) ([]models.Submission, error)
+	CountAttempts(ctx context.Context, userID int64, testID int) (int, error)
+	// ResetAttempts voids the user's previous submissions so the attempt
+	// limit starts over, and returns how many were voided.
+	ResetAttempts(ctx context.Context, userID int64, testID int) (int64, error)
+	// Leaderboard returns the top entries of the test ranked by each user's
+	// best score, ties broken by submission time, plus the entry of userID if
+	// it falls outside the top. A limit of 0 returns only the entry of userID.
+	Leaderboard(ctx context.Context, testID int, filter models.RankFilter, limit int, userID int64) ([]models.RankEntry, error)
+}
+
+type SessionRepo interface {
+	// Start records when the user received the test file. An existing
+	// session is kept so that requesting the file again does not extend the
+	// deadline.
+	Start(ctx context.Context, userID int64, testID int, deadline time.Time) (models.TestSession, error)
+	Get(ctx context.Context, userID int64, testID int) (models.TestSession, error)
+	Delete(ctx context.Context, userID int64, testID int) error
+	// ToRemind returns running sessions that end before the given time, have
+	// not been reminded yet and have no submission since they started.
+	ToRemind(ctx context.Context, before time.Time) ([]models.TestSession, error)
+	MarkReminded(ctx context.Context, userID int64, testID int) error
+}
+
+type CertificateRepo interface {
+	// Add stores a new certificate. If the user already has one for the test,
+	// the existing certificate is returned instead.
+	Add(ctx context.Context, certificate models.Certificate) (models.Certificate, error)
+	ByCode(ctx context.Context, code string) (models.Certificate, error)
+}
+
+type SettingRepo interface {
+	// Get returns ErrNotFound if the setting was never set.
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string) error
+}
+
+type AuditRepo interface {
+	Add(ctx context.Context, entry models.AuditEntry) error
+	// Page returns limit entries after skipping offset, newest first.
+	Page(ctx context.Context, limit, offset int) ([]models.AuditEntry, error)
+	All(ctx context.Context) ([]models.AuditEntry, error)
+	Count(ctx context.Context) (int, error)
+}
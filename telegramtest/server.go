@@ -0,0 +1,405 @@
+// Package telegramtest provides an in-process fake of the Telegram Bot API
+// for running the bot's handlers without network access.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Token is the bot token the fake server accepts.
+const Token = "123456:TEST"
+
+// BotID is the user ID of the bot returned by getMe.
+const BotID = 123456
+
+// Request is a Bot API call received by the server.
+type Request struct {
+	Method string
+	Params url.Values
+	// Upload is set when the call uploaded a file.
+	Upload *Upload
+}
+
+// Upload is a file sent by the bot.
+type Upload struct {
+	Name string
+	Data []byte
+}
+
+// ChatID returns the chat the request was made for, or 0.
+func (r Request) ChatID() int64 {
+	chatID, _ := strconv.ParseInt(r.Params.Get("chat_id"), 10, 64)
+	return chatID
+}
+
+// Text returns the text or caption of a sent message.
+func (r Request) Text() string {
+	if text := r.Params.Get("text"); text != "" {
+		return text
+	}
+	return r.Params.Get("caption")
+}
+
+// CallbackData returns the data of every inline button attached to the
+// message, row by row.
+func (r Request) CallbackData() []string {
+	var markup tgbotapi.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(r.Params.Get("reply_markup")), &markup); err != nil {
+		return nil
+	}
+	var data []string
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData != nil {
+				data = append(data, *button.CallbackData)
+			}
+		}
+	}
+	return data
+}
+
+type file struct {
+	name string
+	data []byte
+}
+
+type member struct {
+	chatID int64
+	userID int
+}
+
+// Server answers Bot API calls from memory. It records every call, serves
+// files added with AddFile, and queues updates built by its helpers for
+// getUpdates. Channels are unknown and users are not members of any chat
+// until set up with AddChat and SetMember.
+type Server struct {
+	mu            sync.Mutex
+	requests      []Request
+	updates       []tgbotapi.Update
+	nextUpdateID  int
+	nextMessageID int
+	files         map[string]file
+	chats         map[string]int64
+	members       map[member]string
+}
+
+func NewServer() *Server {
+	return &Server{
+		nextUpdateID:  1,
+		nextMessageID: 1,
+		files:         make(map[string]file),
+		chats:         make(map[string]int64),
+		members:       make(map[member]string),
+	}
+}
+
+// Client returns an HTTP client that sends every request to the server
+// instead of the network, whatever its host.
+func (s *Server) Client() *http.Client {
+	return &http.Client{Transport: transport{s}}
+}
+
+// Bot returns a bot whose requests, file downloads included, go to the server.
+func (s *Server) Bot() (*tgbotapi.BotAPI, error) {
+	return tgbotapi.NewBotAPIWithClient(Token, s.Client())
+}
+
+type transport struct {
+	handler http.Handler
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Like the real transport, give up on requests whose context is done
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, req)
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return recorder.Result(), nil
+}
+
+// AddFile makes the file available to getFile and downloads.
+func (s *Server) AddFile(fileID, name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[fileID] = file{name: name, data: data}
+}
+
+// AddChat makes the public chat known to getChat by its username.
+func (s *Server) AddChat(username string, chatID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chats[strings.TrimPrefix(username, "@")] = chatID
+}
+
+// SetMember sets the status getChatMember returns for the user in the chat,
+// such as "member" or "left".
+func (s *Server) SetMember(chatID int64, userID int, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members[member{chatID: chatID, userID: userID}] = status
+}
+
+// Requests returns every call received so far, oldest first.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Sent returns the messages sent to the chat, oldest first.
+func (s *Server) Sent(chatID int64) []Request {
+	var sent []Request
+	for _, req := range s.Requests() {
+		if strings.HasPrefix(req.Method, "send") && req.ChatID() == chatID {
+			sent = append(sent, req)
+		}
+	}
+	return sent
+}
+
+// Texts returns the texts and captions of the messages sent to the chat.
+func (s *Server) Texts(chatID int64) []string {
+	var texts []string
+	for _, req := range s.Sent(chatID) {
+		texts = append(texts, req.Text())
+	}
+	return texts
+}
+
+// Reset forgets the recorded calls, keeping files, chats and queued updates.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+// Text queues and returns a text message from the user.
+func (s *Server) Text(userID int, text string) tgbotapi.Update {
+	return s.push(func(message *tgbotapi.Message) {
+		message.Text = text
+	}, userID)
+}
+
+// Document queues and returns a document from the user; its contents can
+// be downloaded after AddFile.
+func (s *Server) Document(userID int, fileID, fileName, mimeType string) tgbotapi.Update {
+	return s.push(func(message *tgbotapi.Message) {
+		message.Document = &tgbotapi.Document{FileID: fileID, FileName: fileName, MimeType: mimeType}
+	}, userID)
+}
+
+// Contact queues and returns the user sharing their own phone number.
+func (s *Server) Contact(userID int, phone string) tgbotapi.Update {
+	return s.push(func(message *tgbotapi.Message) {
+		message.Contact = &tgbotapi.Contact{PhoneNumber: phone, FirstName: message.From.FirstName, UserID: userID}
+	}, userID)
+}
+
+// Callback queues and returns the user pressing an inline button with the
+// data under the message.
+func (s *Server) Callback(userID int, messageID int, data string) tgbotapi.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update := tgbotapi.Update{
+		UpdateID: s.nextUpdateID,
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:   strconv.Itoa(s.nextUpdateID),
+			From: user(userID),
+			Message: &tgbotapi.Message{
+				MessageID: messageID,
+				Chat:      privateChat(userID),
+				Date:      int(time.Now().Unix()),
+			},
+			Data: data,
+		},
+	}
+	s.nextUpdateID++
+	s.updates = append(s.updates, update)
+	return update
+}
+
+func (s *Server) push(fill func(*tgbotapi.Message), userID int) tgbotapi.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := &tgbotapi.Message{
+		MessageID: s.nextMessageID,
+		From:      user(userID),
+		Chat:      privateChat(userID),
+		Date:      int(time.Now().Unix()),
+	}
+	fill(message)
+	update := tgbotapi.Update{UpdateID: s.nextUpdateID, Message: message}
+	s.nextMessageID++
+	s.nextUpdateID++
+	s.updates = append(s.updates, update)
+	return update
+}
+
+func user(userID int) *tgbotapi.User {
+	return &tgbotapi.User{ID: userID, FirstName: fmt.Sprintf("User %d", userID)}
+}
+
+func privateChat(userID int) *tgbotapi.Chat {
+	return &tgbotapi.Chat{ID: int64(userID), Type: "private"}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if path, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+Token+"/"); ok {
+		s.serveFile(w, path)
+		return
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+Token+"/")
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	req, err := readRequest(method, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+
+	switch {
+	case method == "getMe":
+		writeResult(w, tgbotapi.User{ID: BotID, FirstName: "Test", UserName: "test_bot", IsBot: true})
+	case method == "getUpdates":
+		offset, _ := strconv.Atoi(req.Params.Get("offset"))
+		var pending []tgbotapi.Update
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				pending = append(pending, update)
+			}
+		}
+		s.updates = pending
+		writeResult(w, append([]tgbotapi.Update{}, pending...))
+	case method == "getFile":
+		fileID := req.Params.Get("file_id")
+		if _, ok := s.files[fileID]; !ok {
+			writeError(w, http.StatusBadRequest, "Bad Request: invalid file_id")
+			return
+		}
+		writeResult(w, tgbotapi.File{FileID: fileID, FilePath: "documents/" + fileID})
+	case method == "getChat":
+		chatID, ok := s.chatID(req.Params.Get("chat_id"))
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Bad Request: chat not found")
+			return
+		}
+		writeResult(w, tgbotapi.Chat{ID: chatID, Type: "channel", UserName: strings.TrimPrefix(req.Params.Get("chat_id"), "@")})
+	case method == "getChatMember":
+		chatID, ok := s.chatID(req.Params.Get("chat_id"))
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Bad Request: chat not found")
+			return
+		}
+		userID, _ := strconv.Atoi(req.Params.Get("user_id"))
+		status, ok := s.members[member{chatID: chatID, userID: userID}]
+		if !ok {
+			status = "left"
+		}
+		writeResult(w, tgbotapi.ChatMember{User: user(userID), Status: status})
+	case strings.HasPrefix(method, "send"):
+		message := tgbotapi.Message{
+			MessageID: s.nextMessageID,
+			From:      &tgbotapi.User{ID: BotID, FirstName: "Test", UserName: "test_bot", IsBot: true},
+			Chat:      &tgbotapi.Chat{ID: req.ChatID()},
+			Date:      int(time.Now().Unix()),
+			Text:      req.Params.Get("text"),
+			Caption:   req.Params.Get("caption"),
+		}
+		if req.Upload != nil {
+			message.Document = &tgbotapi.Document{FileID: fmt.Sprintf("upload_%d", s.nextMessageID), FileName: req.Upload.Name, FileSize: len(req.Upload.Data)}
+		}
+		s.nextMessageID++
+		writeResult(w, message)
+	default:
+		// deleteMessage, answerCallbackQuery, setWebhook and the like
+		writeResult(w, true)
+	}
+}
+
+func (s *Server) chatID(chat string) (int64, bool) {
+	if username, ok := strings.CutPrefix(chat, "@"); ok {
+		chatID, ok := s.chats[username]
+		return chatID, ok
+	}
+	chatID, err := strconv.ParseInt(chat, 10, 64)
+	return chatID, err == nil
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, path string) {
+	s.mu.Lock()
+	stored, ok := s.files[strings.TrimPrefix(path, "documents/")]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	w.Write(stored.data)
+}
+
+func readRequest(method string, r *http.Request) (Request, error) {
+	req := Request{Method: method}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return req, err
+		}
+		req.Params = url.Values(r.MultipartForm.Value)
+		for _, headers := range r.MultipartForm.File {
+			upload, err := headers[0].Open()
+			if err != nil {
+				return req, err
+			}
+			data, err := io.ReadAll(upload)
+			upload.Close()
+			if err != nil {
+				return req, err
+			}
+			req.Upload = &Upload{Name: headers[0].Filename, Data: data}
+		}
+		return req, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return req, err
+	}
+	req.Params = r.PostForm
+	return req, nil
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: data})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{ErrorCode: code, Description: description})
+}
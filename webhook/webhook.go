@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// SecretHeader carries the secret token Telegram sends with every update.
+const SecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// NewHandler returns a handler that accepts updates posted by Telegram and
+// passes them to dispatch. Requests without the expected secret token are
+// rejected, and updates dispatch cannot accept are answered with 503 so that
+// Telegram delivers them again later.
+func NewHandler(secretToken string, dispatch func(context.Context, tgbotapi.Update) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.Header.Get(SecretHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secretToken)) != 1 {
+			slog.Warn("Rejected webhook request with invalid secret token", "remote_addr", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var update tgbotapi.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&update); err != nil {
+			slog.Error("Error decoding webhook update", "err", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		if err := dispatch(r.Context(), update); err != nil {
+			slog.Error("Error dispatching webhook update", "update_id", update.UpdateID, "err", err)
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// Register tells Telegram to deliver updates to the public URL with the secret token.
+func Register(botInstance *tgbotapi.BotAPI, publicURL, secretToken string) error {
+	params := url.Values{}
+	params.Set("url", publicURL)
+	params.Set("secret_token", secretToken)
+
+	resp, err := botInstance.MakeRequest("setWebhook", params)
+	if err != nil {
+		return fmt.Errorf("error setting webhook: %v", err)
+	}
+	if !resp.Ok {
+		return fmt.Errorf("error setting webhook: %s", resp.Description)
+	}
+	return nil
+}
+
+// Serve listens on addr and serves handler at path until the context is
+// cancelled. TLS is used when both certificate files are given; otherwise the
+// server expects a reverse proxy to terminate HTTPS.
+func Serve(ctx context.Context, addr, path, certFile, keyFile string, handler http.Handler) error {
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if certFile != "" && keyFile != "" {
+			errs <- server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
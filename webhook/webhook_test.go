@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	secret = "s3cret_token-1"
+	update = `{"update_id": 42, "message": {"message_id": 7, "chat": {"id": 5, "type": "private"}, "text": "/start"}}`
+)
+
+func post(handler http.Handler, method, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/telegram/webhook", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set(SecretHeader, token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		token       string
+		body        string
+		dispatchErr error
+		wantStatus  int
+		wantUpdate  bool
+	}{
+		{name: "valid update", method: http.MethodPost, token: secret, body: update, wantStatus: http.StatusOK, wantUpdate: true},
+		{name: "missing token", method: http.MethodPost, body: update, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodPost, token: "guess", body: update, wantStatus: http.StatusUnauthorized},
+		{name: "token prefix", method: http.MethodPost, token: secret[:4], body: update, wantStatus: http.StatusUnauthorized},
+		{name: "dispatch fails", method: http.MethodPost, token: secret, body: update, dispatchErr: errors.New("pool is closed"), wantStatus: http.StatusServiceUnavailable, wantUpdate: true},
+		{name: "invalid body", method: http.MethodPost, token: secret, body: "{", wantStatus: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, token: secret, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []tgbotapi.Update
+			handler := NewHandler(secret, func(ctx context.Context, u tgbotapi.Update) error {
+				got = append(got, u)
+				return tt.dispatchErr
+			})
+
+			rec := post(handler, tt.method, tt.token, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !tt.wantUpdate {
+				if len(got) != 0 {
+					t.Errorf("dispatched %d updates, want none", len(got))
+				}
+				return
+			}
+			if len(got) != 1 {
+				t.Fatalf("dispatched %d updates, want 1", len(got))
+			}
+			if got[0].UpdateID != 42 || got[0].Message == nil || got[0].Message.Chat.ID != 5 || got[0].Message.Text != "/start" {
+				t.Errorf("dispatched %+v", got[0])
+			}
+		})
+	}
+}